@@ -15,30 +15,100 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"flag"
+	"fmt"
+	"io"
 	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
+	// Embeds the IANA timezone database for --timezone in case the container
+	// doesn't provide one.
+	_ "time/tzdata"
+
+	"github.com/ssafty/adhan-homeassistant-pi/automation"
+	"github.com/ssafty/adhan-homeassistant-pi/clock"
+	"github.com/ssafty/adhan-homeassistant-pi/dryrun"
+	"github.com/ssafty/adhan-homeassistant-pi/homeassistant"
+	"github.com/ssafty/adhan-homeassistant-pi/httpclient"
+	"github.com/ssafty/adhan-homeassistant-pi/player"
+	"github.com/ssafty/adhan-homeassistant-pi/prayertimes"
 )
 
 var (
-	speakerSwitchID        = flag.String("switch_id", "", "Id of the speaker switch in home assistant.")
-	homeassistantIp        = flag.String("homeassistant_ip", "", "IP of the local home assistant instance.")
-	homeassistantToken     = flag.String("homeassistant_token", "", "Autherization token for home assistant.")
-	adhan_mp3_fpath        = flag.String("adhan_mp3_fpath", "adhan.mp3", "Path to the Adhan mp3 file e.g. /Users/userA/adhan.mp3")
-	speaker_pause_duration = flag.Duration("speaker_pause", 10*time.Second, "Waiting period between switching on the speaker and playing adhan (default: 10 seconds).")
+	speakerSwitchID           = flag.String("switch_id", "", "Id of the speaker switch in home assistant.")
+	homeassistantIp           = flag.String("homeassistant_ip", "", "IP of the local home assistant instance.")
+	homeassistantToken        = flag.String("homeassistant_token", "", "Autherization token for home assistant.")
+	adhan_mp3_fpath           = flag.String("adhan_mp3_fpath", "adhan.mp3", "Path to the Adhan mp3 file e.g. /Users/userA/adhan.mp3")
+	chimes                    = flag.String("chimes", "", "Comma separated times to play a chime at: "+strings.Join(automation.CHIME_EVENTS, ", ")+" e.g. sunrise to mark the end of Fajr.")
+	chime_mp3_fpath           = flag.String("chime_mp3_fpath", "", "Path to the chime mp3 file played by --chimes. It must have the sampling rate of the Adhan.")
+	ramadan                   = flag.String("ramadan", string(automation.RAMADAN_OFF), "Ramadan mode playing the Suhoor and Iftar audio: off, auto (by the Hijri date) or on.")
+	suhoor_warning            = flag.Duration("suhoor_warning", 30*time.Minute, "Time before Fajr to alert the end of Suhoor in Ramadan.")
+	suhoor_mp3_fpath          = flag.String("suhoor_mp3_fpath", "", "Path to the mp3 file alerting the end of Suhoor in Ramadan.")
+	iftar_mp3_fpath           = flag.String("iftar_mp3_fpath", "", "Path to the mp3 file played instead of the Maghrib Adhan in Ramadan.")
+	jumuah_adhan_mp3_fpath    = flag.String("jumuah_adhan_mp3_fpath", "", "Path to the mp3 file played instead of the Dhuhr Adhan on Fridays.")
+	jumuah_reminder           = flag.Duration("jumuah_reminder", 45*time.Minute, "Time before Dhuhr to remind of Jumu'ah on Fridays.")
+	jumuah_reminder_mp3_fpath = flag.String("jumuah_reminder_mp3_fpath", "", "Path to the mp3 file reminding of Jumu'ah. Enables the reminder.")
+	kahf_reminder             = flag.String("kahf_reminder", "09:00", "Time of day (hh:mm) to remind to read Surah al-Kahf on Fridays.")
+	kahf_reminder_mp3_fpath   = flag.String("kahf_reminder_mp3_fpath", "", "Path to the mp3 file reminding to read Surah al-Kahf. Enables the reminder.")
+	iqama_mp3_fpath           = flag.String("iqama_mp3_fpath", "", "Path to the mp3 file calling the iqama after the Adhan. Enables the iqama of prayers with a delay or a published iqama e.g. by --prayer_times=mawaqit.")
+	iqama_fajr                = flag.Duration("iqama_fajr", 0, "Time after the Fajr Adhan to call the iqama e.g. 20m (default: the published iqama, if any).")
+	iqama_dhuhr               = flag.Duration("iqama_dhuhr", 0, "Time after the Dhuhr Adhan to call the iqama e.g. 10m (default: the published iqama, if any).")
+	iqama_asr                 = flag.Duration("iqama_asr", 0, "Time after the Asr Adhan to call the iqama e.g. 10m (default: the published iqama, if any).")
+	iqama_maghrib             = flag.Duration("iqama_maghrib", 0, "Time after the Maghrib Adhan to call the iqama e.g. 5m (default: the published iqama, if any).")
+	iqama_isha                = flag.Duration("iqama_isha", 0, "Time after the Ishaa Adhan to call the iqama e.g. 10m (default: the published iqama, if any).")
+	state_fpath               = flag.String("state_fpath", "adhan_state.json", "Path to the file persisting the played events, so a restart neither replays nor skips an event. Empty disables it.")
+	catch_up_window           = flag.Duration("catch_up_window", automation.DEFAULT_CATCH_UP_WINDOW, "How late an event missed e.g. during a restart may still play. Older events are skipped.")
+	speaker_pause_duration    = flag.Duration("speaker_pause", 10*time.Second, "Waiting period between switching on the speaker and playing adhan (default: 10 seconds).")
+
+	dryRun = flag.Bool("dry_run", false, "Log the switch actions and plays instead of sending them to home assistant and the audio device e.g. to try a configuration on a laptop. The home assistant flags are optional.")
+
+	timezone = flag.String("timezone", "", "IANA timezone of the prayer times and the automation e.g. Europe/Berlin (default: the local timezone from TZ or /etc/localtime).")
+
+	prayerTimesSource = flag.String("prayer_times", "munich", "Source of the prayer times: munich (static 2023 timetable, calculated for Munich on other days), calculated, timetable, aladhan or mawaqit.")
+	aladhanURL        = flag.String("aladhan_url", prayertimes.ALADHAN_URL, "Base URL of the Aladhan-compatible API used by --prayer_times=aladhan.")
+	aladhanCacheDir   = flag.String("aladhan_cache_dir", "aladhan_cache", "Directory caching the months fetched by --prayer_times=aladhan.")
+	mawaqitSource     = flag.String("mawaqit_source", "", "Path or http(s) URL of the mosque's Mawaqit JSON with prayer and iqama times used by --prayer_times=mawaqit.")
+	timetableFpath    = flag.String("timetable_fpath", "", "Comma separated paths to CSV or JSON timetables used by --prayer_times=timetable e.g. /timetables/2024.csv,/timetables/2025.csv")
+	timetableFallback = flag.Bool("timetable_fallback", false, "Use the calculated prayer times for days not covered by the timetable or the Mawaqit calendar instead of failing. Always on for munich prayer times.")
+	latitude          = flag.Float64("latitude", 0, "Latitude in degrees used by the calculated prayer times e.g. 48.1374")
+	longitude         = flag.Float64("longitude", 0, "Longitude in degrees used by the calculated prayer times e.g. 11.5755")
+	elevation         = flag.Float64("elevation", 0, "Elevation above sea level in meters used by the calculated prayer times.")
+	calcMethodName    = flag.String("calc_method", prayertimes.DEFAULT_METHOD, "Calculation method of the calculated prayer times: "+prayertimes.CalcMethodNames())
+	fajrAngle         = flag.Float64("fajr_angle", 0, "Fajr twilight angle in degrees. Used by --calc_method=custom.")
+	ishaAngle         = flag.Float64("isha_angle", 0, "Ishaa twilight angle in degrees. Used by --calc_method=custom.")
+	ishaInterval      = flag.Duration("isha_interval", 0, "Ishaa interval after Maghrib e.g. 90m. Used by --calc_method=custom instead of --isha_angle.")
+	highLatRule       = flag.String("high_lat_rule", string(prayertimes.ANGLE_BASED), "Fajr/Ishaa adjustment of the calculated prayer times at high latitudes: none, middle_of_night, one_seventh or angle_based.")
+	asrFactor         = flag.Int("asr_factor", 1, "Asr shadow factor of the calculated prayer times: 1 (Shafi'i, Maliki, Hanbali) or 2 (Hanafi).")
+	secondAsr         = flag.Bool("second_asr", false, "Also play the Adhan at the Asr time of the other shadow factor. Only supported by calculated prayer times.")
+
+	hijriAdjustment = flag.Int("hijri_adjustment", 0, "Days added to the tabular Hijri date to follow local moon sighting e.g. -1 or +1.")
+
+	offsetFajr    = flag.Duration("offset_fajr", 0, "Offset added to Fajr time e.g. +2m or -1m.")
+	offsetDhuhr   = flag.Duration("offset_dhuhr", 0, "Offset added to Dhuhr time e.g. +2m or -1m.")
+	offsetAsr     = flag.Duration("offset_asr", 0, "Offset added to Asr time e.g. +2m or -1m.")
+	offsetMaghrib = flag.Duration("offset_maghrib", 0, "Offset added to Maghrib time e.g. +3m.")
+	offsetIsha    = flag.Duration("offset_isha", 0, "Offset added to Ishaa time e.g. +2m or -1m.")
+
+	simulateFrom     = flag.String("simulate_from", "", "First day (yyyy-mm-dd) simulated by the simulate command (default: today).")
+	simulateTo       = flag.String("simulate_to", "", "Day (yyyy-mm-dd) the simulate command stops at, excluded (default: a year after --simulate_from).")
+	simulatePlayback = flag.Duration("simulate_playback", 4*time.Minute, "Length of the audio played by the simulate command.")
+	maxSpeakerOn     = flag.Duration("max_speaker_on", 15*time.Minute, "Longest time the simulate command lets the speakers stay on before reporting an anomaly.")
 )
 
 const (
 	SAMPLE_RATE     = 44100
 	NUM_CHANNELS    = 2
 	AUDIO_BIT_DEPTH = 2
-)
 
-func sleep(t time.Duration) {
-	log.Printf("Sleeping for %v until %v", t, time.Now().Add(t))
-	time.Sleep(t)
-}
+	// SHUTDOWN_TIMEOUT bounds stopping the playback and switching the
+	// speakers off, within the 10 seconds docker stop waits before killing.
+	SHUTDOWN_TIMEOUT = 8 * time.Second
+)
 
 func assertFlags() error {
 	switch {
@@ -49,53 +119,453 @@ func assertFlags() error {
 	case *homeassistantToken == "":
 		return errors.New("homeassistant_token flag is not set.")
 	}
+	return assertAutomationFlags()
+}
+
+// assertAutomationFlags checks the flags of the prayer times and the events,
+// which the simulate command uses without home assistant.
+func assertAutomationFlags() error {
+	switch {
+	case (*prayerTimesSource == "calculated" || *prayerTimesSource == "aladhan") && (!isFlagSet("latitude") || !isFlagSet("longitude")):
+		return fmt.Errorf("latitude and longitude flags are required by %s prayer times.", *prayerTimesSource)
+	case (*prayerTimesSource == "timetable" || *prayerTimesSource == "mawaqit") && *timetableFallback && (!isFlagSet("latitude") || !isFlagSet("longitude")):
+		return errors.New("latitude and longitude flags are required by the timetable fallback.")
+	case *secondAsr && *prayerTimesSource != "calculated":
+		// Timetables and Aladhan have a single Asr, unlike their fallback days.
+		return fmt.Errorf("second_asr flag is not supported by %s prayer times, only by calculated ones.", *prayerTimesSource)
+	case *prayerTimesSource == "timetable" && *timetableFpath == "":
+		return errors.New("timetable_fpath flag is required by timetable prayer times.")
+	case *prayerTimesSource == "mawaqit" && *mawaqitSource == "":
+		return errors.New("mawaqit_source flag is required by mawaqit prayer times.")
+	case *hijriAdjustment < -prayertimes.MAX_HIJRI_ADJUSTMENT || *hijriAdjustment > prayertimes.MAX_HIJRI_ADJUSTMENT:
+		return fmt.Errorf("hijri_adjustment flag must be within ±%d days.", prayertimes.MAX_HIJRI_ADJUSTMENT)
+	case *chimes != "" && *chime_mp3_fpath == "":
+		return errors.New("chime_mp3_fpath flag is required by chimes.")
+	case *jumuah_reminder_mp3_fpath != "" && *jumuah_reminder <= 0:
+		return errors.New("jumuah_reminder flag must be positive.")
+	case *kahf_reminder_mp3_fpath != "" && !prayertimes.TimeFormat.MatchString(*kahf_reminder):
+		return fmt.Errorf("kahf_reminder flag %q is not a time of day (hh:mm).", *kahf_reminder)
+	case *ramadan != string(automation.RAMADAN_OFF) && *suhoor_mp3_fpath == "" && *iftar_mp3_fpath == "":
+		return errors.New("suhoor_mp3_fpath or iftar_mp3_fpath flags are required by the Ramadan mode.")
+	case *catch_up_window < automation.MAX_TIMER:
+		return fmt.Errorf("catch_up_window flag must be at least %v.", automation.MAX_TIMER)
+	case len(iqamaDelays()) > 0 && *iqama_mp3_fpath == "":
+		return errors.New("iqama_mp3_fpath flag is required by the iqama delays.")
+	case *iqama_mp3_fpath != "" && len(iqamaDelays()) == 0 && *prayerTimesSource != "mawaqit":
+		return errors.New("iqama delay flags e.g. iqama_fajr are required by iqama_mp3_fpath, unless the mawaqit prayer times publish the iqama.")
+	}
+	return nil
+}
+
+// isFlagSet returns True if the flag was explicitly passed on the command line.
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// loadTimezone returns the location of --timezone, defaulting to time.Local.
+func loadTimezone() (*time.Location, error) {
+	if *timezone == "" {
+		return time.Local, nil
+	}
+	loc, err := time.LoadLocation(*timezone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timezone %q: %w", *timezone, err)
+	}
+	return loc, nil
+}
+
+// newPrayerTimes initializes the IPrayerTimes implementation selected by flags.
+// clock reads today's date, nil is the system clock. ctx bounds fetching the
+// prayer times.
+func newPrayerTimes(ctx context.Context, loc *time.Location, c clock.Clock) (prayertimes.IPrayerTimes, error) {
+	opts := []prayertimes.PrayerTimesOpt{prayertimes.Offsets(map[string]time.Duration{
+		"Fajr":    *offsetFajr,
+		"Dhuhr":   *offsetDhuhr,
+		"Asr":     *offsetAsr,
+		"Maghrib": *offsetMaghrib,
+		"Ishaa":   *offsetIsha,
+	}), prayertimes.Timezone(loc), prayertimes.HijriAdjustment(*hijriAdjustment), prayertimes.PrayerTimesClock(c)}
+
+	// The Munich timetable only covers 2023, so later days are calculated.
+	if (*timetableFallback && *prayerTimesSource != "calculated") || *prayerTimesSource == "munich" {
+		if *prayerTimesSource == "munich" && !isFlagSet("latitude") && !isFlagSet("longitude") {
+			*latitude, *longitude = prayertimes.MUNICH_LATITUDE, prayertimes.MUNICH_LONGITUDE
+		}
+		params, err := calculationParams(loc)
+		if err != nil {
+			return nil, fmt.Errorf("error initializing the timetable fallback: %w", err)
+		}
+		fallback, err := prayertimes.NewCalculatedPrayerTimes(params, prayertimes.PrayerTimesClock(c))
+		if err != nil {
+			return nil, fmt.Errorf("error initializing the timetable fallback: %w", err)
+		}
+		opts = append(opts, prayertimes.Fallback(fallback))
+	}
+
+	switch *prayerTimesSource {
+	case "munich":
+		return prayertimes.NewMunichPrayerTimes(opts...)
+	case "calculated":
+		params, err := calculationParams(loc)
+		if err != nil {
+			return nil, err
+		}
+		return prayertimes.NewCalculatedPrayerTimes(params, opts...)
+	case "timetable":
+		return prayertimes.NewTimetablePrayerTimes(strings.Split(*timetableFpath, ","), opts...)
+	case "mawaqit":
+		return prayertimes.NewMawaqitPrayerTimes(ctx, *mawaqitSource, opts...)
+	case "aladhan":
+		method, err := prayertimes.GetCalcMethod(*calcMethodName, *fajrAngle, *ishaAngle, *ishaInterval)
+		if err != nil {
+			return nil, err
+		}
+		return prayertimes.NewAladhanPrayerTimes(prayertimes.AladhanParams{
+			BaseURL:   *aladhanURL,
+			CacheDir:  *aladhanCacheDir,
+			Latitude:  *latitude,
+			Longitude: *longitude,
+			Method:    method,
+			AsrFactor: *asrFactor,
+		}, opts...)
+	default:
+		return nil, fmt.Errorf("unknown prayer_times source: %q", *prayerTimesSource)
+	}
+}
+
+// calculationParams returns the parameters of the prayer times calculator set
+// by flags.
+func calculationParams(loc *time.Location) (prayertimes.CalculationParams, error) {
+	method, err := prayertimes.GetCalcMethod(*calcMethodName, *fajrAngle, *ishaAngle, *ishaInterval)
+	if err != nil {
+		return prayertimes.CalculationParams{}, err
+	}
+	return prayertimes.CalculationParams{
+		Latitude:    *latitude,
+		Longitude:   *longitude,
+		Elevation:   *elevation,
+		Method:      method,
+		AsrFactor:   *asrFactor,
+		SecondAsr:   *secondAsr,
+		HighLatRule: prayertimes.HighLatitudeRule(*highLatRule),
+		Timezone:    loc,
+	}, nil
+}
+
+// newPlayer initializes a player of an mp3 file in the Adhan's audio format.
+func newPlayer(fpath string) (automation.IAdhanPlayer, error) {
+	p, err := player.NewAdhanPlayer(
+		player.FilePath(fpath),
+		player.SamplingRate(SAMPLE_RATE),
+		player.NumChannels(NUM_CHANNELS),
+		player.AudioBitDepth(AUDIO_BIT_DEPTH),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// iqamaDelays returns the iqama delays set by flags per prayer name.
+func iqamaDelays() map[string]time.Duration {
+	delays := map[string]time.Duration{}
+	for name, delay := range map[string]time.Duration{
+		"Fajr":    *iqama_fajr,
+		"Dhuhr":   *iqama_dhuhr,
+		"Asr":     *iqama_asr,
+		"Maghrib": *iqama_maghrib,
+		"Ishaa":   *iqama_isha,
+	} {
+		if delay != 0 {
+			delays[name] = delay
+		}
+	}
+	return delays
+}
+
+// newAudioPlayer initializes a player of an mp3 file, or a logging one by
+// --dry_run that doesn't need an audio device.
+func newAudioPlayer(fpath string) (automation.IAdhanPlayer, error) {
+	if *dryRun {
+		p, err := dryrun.NewLoggingPlayer(fpath)
+		if err != nil {
+			return nil, err
+		}
+		return p, nil
+	}
+	return newPlayer(fpath)
+}
+
+// newSwitch initializes the home assistant switch of the speakers, or a
+// logging one by --dry_run.
+func newSwitch() (automation.IHomeAssistant, error) {
+	if *dryRun {
+		switchID := *speakerSwitchID
+		if switchID == "" {
+			switchID = "the speakers"
+		}
+		return dryrun.NewLoggingSwitch(switchID), nil
+	}
+	ha, err := homeassistant.NewHomeAssistant(
+		homeassistant.HTTPClient(httpclient.NewHTTPClient(*homeassistantToken)),
+		homeassistant.SwitchID(*speakerSwitchID),
+		homeassistant.IPAddress(*homeassistantIp))
+	if err != nil {
+		return nil, err
+	}
+	return ha, nil
+}
+
+// newAutomationOpts initializes the automation options and the players of the
+// events enabled by flags. newPlayer initializes the players e.g. simulated
+// ones.
+func newAutomationOpts(newPlayer func(fpath string) (automation.IAdhanPlayer, error)) ([]automation.AutomationOpt, error) {
+	opts := []automation.AutomationOpt{
+		automation.SpeakerPause(speaker_pause_duration),
+		automation.Ramadan(automation.RamadanMode(*ramadan)),
+		automation.StateFile(*state_fpath),
+		automation.CatchUpWindow(*catch_up_window),
+	}
+
+	if *chimes != "" {
+		chimePlayer, err := newPlayer(*chime_mp3_fpath)
+		if err != nil {
+			return nil, fmt.Errorf("error initializing the chime player: %w", err)
+		}
+		opts = append(opts, automation.Chimes(chimePlayer, strings.Split(*chimes, ",")...))
+	}
+	if *ramadan != string(automation.RAMADAN_OFF) && *suhoor_mp3_fpath != "" {
+		suhoorPlayer, err := newPlayer(*suhoor_mp3_fpath)
+		if err != nil {
+			return nil, fmt.Errorf("error initializing the Suhoor player: %w", err)
+		}
+		opts = append(opts, automation.Suhoor(suhoorPlayer, *suhoor_warning))
+	}
+	if *ramadan != string(automation.RAMADAN_OFF) && *iftar_mp3_fpath != "" {
+		iftarPlayer, err := newPlayer(*iftar_mp3_fpath)
+		if err != nil {
+			return nil, fmt.Errorf("error initializing the Iftar player: %w", err)
+		}
+		opts = append(opts, automation.Iftar(iftarPlayer))
+	}
+
+	if *jumuah_adhan_mp3_fpath != "" {
+		jumuahPlayer, err := newPlayer(*jumuah_adhan_mp3_fpath)
+		if err != nil {
+			return nil, fmt.Errorf("error initializing the Jumu'ah Adhan player: %w", err)
+		}
+		opts = append(opts, automation.JumuahAdhan(jumuahPlayer))
+	}
+	if *jumuah_reminder_mp3_fpath != "" {
+		reminderPlayer, err := newPlayer(*jumuah_reminder_mp3_fpath)
+		if err != nil {
+			return nil, fmt.Errorf("error initializing the Jumu'ah reminder player: %w", err)
+		}
+		opts = append(opts, automation.JumuahReminder(reminderPlayer, *jumuah_reminder))
+	}
+	if *kahf_reminder_mp3_fpath != "" {
+		at, err := time.Parse(prayertimes.TIME_LAYOUT, *kahf_reminder)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing kahf_reminder: %w", err)
+		}
+		kahfPlayer, err := newPlayer(*kahf_reminder_mp3_fpath)
+		if err != nil {
+			return nil, fmt.Errorf("error initializing the Surah al-Kahf reminder player: %w", err)
+		}
+		opts = append(opts, automation.KahfReminder(kahfPlayer, time.Duration(at.Hour())*time.Hour+time.Duration(at.Minute())*time.Minute))
+	}
+	if *iqama_mp3_fpath != "" {
+		iqamaPlayer, err := newPlayer(*iqama_mp3_fpath)
+		if err != nil {
+			return nil, fmt.Errorf("error initializing the iqama player: %w", err)
+		}
+		opts = append(opts, automation.Iqama(iqamaPlayer, iqamaDelays()))
+	}
+	return opts, nil
+}
+
+// validateTimetables lints the timetable files, defaulting to --timetable_fpath
+// or the static Munich timetable, and logs all issues.
+func validateTimetables(fpaths []string) error {
+	if len(fpaths) == 0 && *timetableFpath != "" {
+		fpaths = strings.Split(*timetableFpath, ",")
+	}
+	return prayertimes.ValidateTimetables(fpaths)
+}
+
+// simulate runs the automation configured by flags from --simulate_from to
+// --simulate_to with a fake clock, simulated players and a simulated switch,
+// and reports every action and anomaly to w. The automation's logs are
+// discarded.
+func simulate(w io.Writer) error {
+	if err := assertAutomationFlags(); err != nil {
+		return err
+	}
+	loc, err := loadTimezone()
+	if err != nil {
+		return fmt.Errorf("failed to load the timezone: %w", err)
+	}
+
+	from := prayertimes.GetDate(time.Now().In(loc))
+	if *simulateFrom != "" {
+		if from, err = time.ParseInLocation(prayertimes.DATE_LAYOUT, *simulateFrom, loc); err != nil {
+			return fmt.Errorf("invalid simulate_from: %w", err)
+		}
+	}
+	to := from.AddDate(1, 0, 0)
+	if *simulateTo != "" {
+		if to, err = time.ParseInLocation(prayertimes.DATE_LAYOUT, *simulateTo, loc); err != nil {
+			return fmt.Errorf("invalid simulate_to: %w", err)
+		}
+	}
+	if !to.After(from) {
+		return fmt.Errorf("simulate_to %v must follow simulate_from %v", to.Format(prayertimes.DATE_LAYOUT), from.Format(prayertimes.DATE_LAYOUT))
+	}
+
+	sim := automation.NewSimulation(from, *simulatePlayback)
+	prayerTimes, err := newPrayerTimes(context.Background(), loc, sim.Clock())
+	if err != nil {
+		return fmt.Errorf("failed to initialize the prayer times: %w", err)
+	}
+	opts, err := newAutomationOpts(func(fpath string) (automation.IAdhanPlayer, error) { return sim.Player(fpath), nil })
+	if err != nil {
+		return err
+	}
+	// The simulation neither reads nor overwrites the state of the automation.
+	opts = append(opts, automation.StateFile(""), automation.AutomationClock(sim.Clock()))
+	a, err := automation.NewAutomation(sim.Player(*adhan_mp3_fpath), sim.Switch(), prayerTimes, opts...)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Simulating %v to %v.", from.Format(prayertimes.DATE_LAYOUT), to.Format(prayertimes.DATE_LAYOUT))
+	logs := log.Writer()
+	log.SetOutput(io.Discard)
+	err = sim.Run(context.Background(), a, loc, to)
+	var anomalies []string
+	if err == nil {
+		anomalies, err = sim.Anomalies(context.Background(), automation.SimulationCheck{
+			Automation:   a,
+			From:         from,
+			To:           to,
+			MaxSpeakerOn: *maxSpeakerOn,
+		})
+	}
+	log.SetOutput(logs)
+	if err != nil {
+		return err
+	}
+	sim.Report(w, from, to, anomalies)
+	if len(anomalies) > 0 {
+		return fmt.Errorf("found %d anomalies", len(anomalies))
+	}
 	return nil
 }
 
 func main() {
 	flag.Parse()
-	if err := assertFlags(); err != nil {
+
+	switch cmd := flag.Arg(0); cmd {
+	case "":
+	case "validate-timetable":
+		// Flags may follow the command e.g. validate-timetable --timetable_fpath=2024.csv
+		flag.CommandLine.Parse(flag.Args()[1:])
+		if err := validateTimetables(flag.Args()); err != nil {
+			log.Fatalf("Timetable validation failed: %v", err)
+		}
+		return
+	case "simulate":
+		// Flags may follow the command e.g. simulate --simulate_from=2024-01-01
+		flag.CommandLine.Parse(flag.Args()[1:])
+		if err := simulate(os.Stdout); err != nil {
+			log.Fatalf("Simulation failed: %v", err)
+		}
+		return
+	default:
+		log.Fatalf("Unknown command %q. Supported commands: validate-timetable, simulate", cmd)
+	}
+
+	checkFlags := assertFlags
+	if *dryRun {
+		log.Printf("Dry run: logging the switch actions and plays.")
+		checkFlags = assertAutomationFlags
+	}
+	if err := checkFlags(); err != nil {
 		log.Fatalf("Some flags are uninitialized: %v", err)
 	}
 
-	homeassistant, err := NewHomeAssistant(
-		HTTPClient(NewHTTPClient(*homeassistantToken)),
-		SwitchID(*speakerSwitchID),
-		IPAddress(*homeassistantIp))
+	location, err := loadTimezone()
+	if err != nil {
+		log.Fatalf("Failed to load the timezone: %v", err)
+	}
+	log.Printf("Using timezone %v", location)
+
+	speakers, err := newSwitch()
 	if err != nil {
 		log.Fatalf("Failed to initialize NewHomeAssistant: %v", err)
 	}
 
-	adhanPlayer, err := NewAdhanPlayer(
-		FilePath(*adhan_mp3_fpath),
-		SamplingRate(SAMPLE_RATE),
-		NumChannels(NUM_CHANNELS),
-		AudioBitDepth(AUDIO_BIT_DEPTH),
-	)
+	adhanPlayer, err := newAudioPlayer(*adhan_mp3_fpath)
 	if err != nil {
 		log.Fatalf("Failed to initialize NewAdhanPlayer: %v", err)
 	}
 
-	prayerTimes, err := NewMunichPrayerTimes()
+	// docker stop sends SIGTERM, Ctrl+C sends SIGINT.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	prayerTimes, err := newPrayerTimes(ctx, location, nil)
 	if err != nil {
 		log.Fatalf("Failed to initialize NewPrayerTimes: %v", err)
 	}
 
-	automation, err := NewAutomation(adhanPlayer, homeassistant, prayerTimes, SpeakerPause(speaker_pause_duration))
+	automationOpts, err := newAutomationOpts(newAudioPlayer)
+	if err != nil {
+		log.Fatalf("Failed to initialize the automation's players: %v", err)
+	}
+	if *dryRun {
+		// A dry run doesn't mark the events of the real automation played.
+		automationOpts = append(automationOpts, automation.StateFile(""))
+	}
+
+	adhanAutomation, err := automation.NewAutomation(adhanPlayer, speakers, prayerTimes, automationOpts...)
 	if err != nil {
 		log.Fatalf("Failed to initialize NewAutomation: %v", err)
 	}
 
-	if err := automation.ValidateAllActions(); err != nil {
-		log.Fatalf("Failed to validate all actions: %v", err)
+	scheduler, err := automation.NewScheduler(adhanAutomation, location)
+	if err != nil {
+		log.Fatalf("Failed to initialize NewScheduler: %v", err)
 	}
 
-	for {
-		sleepDuration, err := automation.RunAndSleep(time.Now())
-		if err != nil {
-			log.Fatalf("Running the automation failed: %v", err)
-		}
+	err = adhanAutomation.ValidateAllActions(ctx)
+	if httpclient.IsTransient(err) {
+		// The automation retries e.g. once homeassistant restarted.
+		log.Printf("Warning: failed to validate all actions: %v", err)
+		err = nil
+	}
+	if err != nil {
+		err = fmt.Errorf("Failed to validate all actions: %w", err)
+	} else {
+		err = scheduler.Run(ctx)
+	}
+	if err != nil && !errors.Is(err, context.Canceled) {
+		log.Printf("%v", err)
+	}
 
-		sleep(sleepDuration)
+	log.Printf("Shutting down.")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), SHUTDOWN_TIMEOUT)
+	defer cancel()
+	if err := adhanAutomation.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Failed to shut down cleanly: %v", err)
+	}
+	if err != nil && !errors.Is(err, context.Canceled) {
+		os.Exit(1)
 	}
 }
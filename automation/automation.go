@@ -0,0 +1,553 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package automation is the decision maker and actuator for adhan playing,
+// waiting and controlling home assistant switches. It accepts any IAdhanPlayer,
+// IHomeAssistant and prayertimes.IPrayerTimes, so it can be reused with other
+// players and switches.
+
+package automation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/ssafty/adhan-homeassistant-pi/clock"
+	"github.com/ssafty/adhan-homeassistant-pi/httpclient"
+	"github.com/ssafty/adhan-homeassistant-pi/prayertimes"
+)
+
+const (
+	FIVE_SECONDS = 5 * time.Second
+
+	ONE_MINUTE   = 1 * time.Minute
+	TWO_MINUTES  = 2 * time.Minute
+	FIVE_MINUTES = 5 * time.Minute
+)
+
+// IAdhanPlayer plays the Adhan and the other events e.g. an mp3 player.
+type IAdhanPlayer interface {
+	// Play starts playing from the start. Cancelling ctx stops the playback.
+	Play(ctx context.Context) error
+	IsPlaying() bool
+	// Stop stops the playback, if playing.
+	Stop()
+}
+
+// IHomeAssistant switches the speakers on and off e.g. a home assistant
+// switch.
+type IHomeAssistant interface {
+	TurnSwitchOn(ctx context.Context) (string, error)
+	TurnSwitchOff(ctx context.Context) (string, error)
+}
+
+// Automation plays the events of the prayer times and switches the speakers
+// around them.
+type Automation struct {
+	adhanPlayer   IAdhanPlayer
+	homeassistant IHomeAssistant
+	prayerTimes   prayertimes.IPrayerTimes
+
+	// time to wait for the speakers to turn on
+	// before playing adhan.
+	speakerPause *time.Duration
+
+	// players play the events other than the Adhan e.g. chimes.
+	players map[eventKind]IAdhanPlayer
+	// chimes are the extra times to play a chime at e.g. "sunrise".
+	chimes map[string]bool
+
+	// ramadan switches on the Suhoor and Iftar events.
+	ramadan RamadanMode
+	// suhoorWarning is the time before Fajr to alert the end of Suhoor.
+	suhoorWarning time.Duration
+
+	// rules add events to specific weekdays e.g. Friday reminders.
+	rules []eventRule
+
+	// iqamaDelays are the times after the Adhan to call the iqama per prayer
+	// name e.g. "Fajr". Prayers without a delay use the iqama published by the
+	// prayer times, if any.
+	iqamaDelays map[string]time.Duration
+
+	// fired are the keys of the actions of the timeline that fired, so each
+	// fires once.
+	fired map[string]bool
+	// lastPlayed is the key of the last played event.
+	lastPlayed string
+	// lastRun is the timestamp of the last RunAndSleep.
+	lastRun time.Time
+	// lastValidated is the timestamp of the last ValidateAllActions, so a
+	// restart doesn't replay the Adhan.
+	lastValidated time.Time
+	// playedUntil is the last time a playback was seen running. Events due
+	// during a playback are queued and play once it ends.
+	playedUntil time.Time
+	// stateFpath persists fired, lastPlayed, lastRun and lastValidated across
+	// restarts.
+	stateFpath string
+	// catchUpWindow is how late an event may still play e.g. after a
+	// restart. It defaults to DEFAULT_CATCH_UP_WINDOW.
+	catchUpWindow time.Duration
+	// switchedOn and switchedOff are set by the last switch action. Both are
+	// unset while the state of the speakers is unknown.
+	switchedOn  bool
+	switchedOff bool
+	// switchFailed is the key of the event whose switch-on failed, so its
+	// play action plays locally without retrying.
+	switchFailed string
+
+	// clock waits the speaker pause. It defaults to the system clock.
+	clock clock.Clock
+}
+
+type AutomationOpt func(*Automation)
+
+func SpeakerPause(d *time.Duration) AutomationOpt {
+	return func(a *Automation) {
+		a.speakerPause = d
+	}
+}
+
+// Chimes plays a chime instead of the Adhan at the named extra times, see
+// CHIME_EVENTS.
+func Chimes(player IAdhanPlayer, names ...string) AutomationOpt {
+	return func(a *Automation) {
+		a.setPlayer(CHIME_EVENT, player)
+		a.chimes = map[string]bool{}
+		for _, name := range names {
+			a.chimes[name] = true
+		}
+	}
+}
+
+// Ramadan enables the Suhoor and Iftar events during Ramadan (auto), always
+// (on) or never (off).
+func Ramadan(mode RamadanMode) AutomationOpt {
+	return func(a *Automation) {
+		a.ramadan = mode
+	}
+}
+
+// Suhoor alerts the end of Suhoor a warning duration before Fajr in Ramadan.
+func Suhoor(player IAdhanPlayer, warning time.Duration) AutomationOpt {
+	return func(a *Automation) {
+		a.setPlayer(SUHOOR_EVENT, player)
+		a.suhoorWarning = warning
+	}
+}
+
+// Iftar plays a distinct audio instead of the Maghrib Adhan in Ramadan.
+func Iftar(player IAdhanPlayer) AutomationOpt {
+	return func(a *Automation) {
+		a.setPlayer(IFTAR_EVENT, player)
+	}
+}
+
+// JumuahAdhan plays a distinct Adhan at Dhuhr on Fridays.
+func JumuahAdhan(player IAdhanPlayer) AutomationOpt {
+	return func(a *Automation) {
+		a.setPlayer(JUMUAH_EVENT, player)
+	}
+}
+
+// JumuahReminder reminds of Jumu'ah a duration before Dhuhr on Fridays.
+func JumuahReminder(player IAdhanPlayer, before time.Duration) AutomationOpt {
+	return func(a *Automation) {
+		a.setPlayer(JUMUAH_REMINDER_EVENT, player)
+		a.rules = append(a.rules, eventRule{
+			weekday: time.Friday,
+			kind:    JUMUAH_REMINDER_EVENT,
+			name:    "Jumu'ah reminder",
+			at:      func(day *prayertimes.PrayerTimes) time.Time { return day.Dhuhr.Time.Add(-before) },
+		})
+	}
+}
+
+// KahfReminder reminds to read Surah al-Kahf on Fridays at a time of day e.g.
+// 9 hours for 09:00.
+func KahfReminder(player IAdhanPlayer, at time.Duration) AutomationOpt {
+	return func(a *Automation) {
+		a.setPlayer(KAHF_REMINDER_EVENT, player)
+		a.rules = append(a.rules, eventRule{
+			weekday: time.Friday,
+			kind:    KAHF_REMINDER_EVENT,
+			name:    "Surah al-Kahf reminder",
+			at: func(day *prayertimes.PrayerTimes) time.Time {
+				return prayertimes.WallClock(day.Date, int(at/time.Hour), int(at%time.Hour/time.Minute))
+			},
+		})
+	}
+}
+
+// Iqama calls the iqama a delay after the Adhan per prayer name, see
+// IQAMA_PRAYERS. Prayers without a delay use the published iqama of prayer
+// times like Mawaqit's. An iqama due before the Adhan ended plays right after
+// it.
+func Iqama(player IAdhanPlayer, delays map[string]time.Duration) AutomationOpt {
+	return func(a *Automation) {
+		a.setPlayer(IQAMA_EVENT, player)
+		a.iqamaDelays = delays
+	}
+}
+
+// StateFile persists the played events to a file, so a restart neither replays
+// nor silently skips an event.
+func StateFile(fpath string) AutomationOpt {
+	return func(a *Automation) {
+		a.stateFpath = fpath
+	}
+}
+
+// CatchUpWindow plays the events that were missed by at most window e.g.
+// during a restart or a suspend. Older events are logged and skipped.
+func CatchUpWindow(window time.Duration) AutomationOpt {
+	return func(a *Automation) {
+		a.catchUpWindow = window
+	}
+}
+
+// AutomationClock replaces the system clock e.g. by a fake clock in
+// simulations. The scheduler uses the automation's clock.
+func AutomationClock(c clock.Clock) AutomationOpt {
+	return func(a *Automation) {
+		a.clock = c
+	}
+}
+
+func (a *Automation) setPlayer(kind eventKind, player IAdhanPlayer) {
+	if a.players == nil {
+		a.players = map[eventKind]IAdhanPlayer{}
+	}
+	a.players[kind] = player
+}
+
+// NewAutomation initializes the automation with any player, switch and prayer
+// times e.g. the logging ones of --dry_run.
+func NewAutomation(ap IAdhanPlayer, ha IHomeAssistant, pa prayertimes.IPrayerTimes, opts ...AutomationOpt) (*Automation, error) {
+	if ap == nil {
+		return nil, errors.New("Automation expects a non-nil AdhanPlayer.")
+	}
+	if ha == nil {
+		return nil, errors.New("Automation expects a non-nil Homeassistant instance.")
+	}
+	if pa == nil {
+		return nil, errors.New("Automation expects a non-nil PrayerTimes instance.")
+	}
+
+	a := &Automation{adhanPlayer: ap, homeassistant: ha, prayerTimes: pa, ramadan: RAMADAN_OFF}
+	for _, opt := range opts {
+		opt(a)
+	}
+
+	if a.speakerPause == nil || *a.speakerPause <= 0 {
+		return nil, errors.New("Automation expects a non-nil positive speaker pause duration.")
+	}
+	for name := range a.chimes {
+		if !isChimeEvent(name) {
+			return nil, fmt.Errorf("Automation got an unknown chime %q. Supported chimes: %v", name, strings.Join(CHIME_EVENTS, ", "))
+		}
+	}
+	for kind, player := range a.players {
+		if player == nil {
+			return nil, fmt.Errorf("Automation expects a non-nil %v player.", kind)
+		}
+	}
+	switch a.ramadan {
+	case RAMADAN_OFF, RAMADAN_AUTO, RAMADAN_ON:
+	default:
+		return nil, fmt.Errorf("Automation got an unknown Ramadan mode %q. Supported modes: off, auto, on", a.ramadan)
+	}
+	if a.players[SUHOOR_EVENT] != nil && a.suhoorWarning <= 0 {
+		return nil, errors.New("Automation expects a positive Suhoor warning duration.")
+	}
+	if a.catchUpWindow != 0 && a.catchUpWindow < MAX_TIMER {
+		return nil, fmt.Errorf("Automation expects a catch-up window of at least %v. Got %v", MAX_TIMER, a.catchUpWindow)
+	}
+	for name, delay := range a.iqamaDelays {
+		if !isIqamaPrayer(name) {
+			return nil, fmt.Errorf("Automation got an iqama delay of an unknown prayer %q. Supported prayers: %v", name, strings.Join(IQAMA_PRAYERS, ", "))
+		}
+		if delay <= 0 {
+			return nil, fmt.Errorf("Automation expects a positive iqama delay of %v. Got %v", name, delay)
+		}
+	}
+	return a, nil
+}
+
+// player returns the player of an event kind.
+func (a *Automation) player(kind eventKind) IAdhanPlayer {
+	if player, ok := a.players[kind]; ok {
+		return player
+	}
+	return a.adhanPlayer
+}
+
+// CatchUp returns how late an event may still play.
+func (a *Automation) CatchUp() time.Duration {
+	if a.catchUpWindow == 0 {
+		return DEFAULT_CATCH_UP_WINDOW
+	}
+	return a.catchUpWindow
+}
+
+// isPlaying returns True if the Adhan or any other event is playing.
+func (a *Automation) isPlaying() bool {
+	if a.adhanPlayer.IsPlaying() {
+		return true
+	}
+	for _, player := range a.players {
+		if player.IsPlaying() {
+			return true
+		}
+	}
+	return false
+}
+
+// RunAndSleep (1) takes decision based on the timeline of the prayer times and current
+// timestamp (2) switches on the speakers before an event, (3) plays it, (4) switches off
+// the speakers once it ended and (5) returns the time until the next action.
+// Cancelling ctx aborts fetching the prayer times, the speaker pause and the
+// playback.
+func (a *Automation) RunAndSleep(ctx context.Context, now time.Time) (time.Duration, error) {
+	if a.isPlaying() {
+		a.playedUntil = now
+		return PLAYBACK_POLL, nil
+	}
+
+	if err := a.prayerTimes.GetTodayPrayerTimes(ctx, now); err != nil {
+		return 0, fmt.Errorf("Failed to repopulate Prayertimes: %w", err)
+	}
+
+	if a.fired == nil {
+		a.restoreState()
+	}
+	timeline := a.timeline()
+	a.forgetFired(timeline)
+	lastRun := a.lastRun
+	a.lastRun = now
+
+	// warming is set if the speakers were switched on late e.g. after a
+	// suspend and need the speaker pause before playing.
+	warming := false
+	for _, act := range timeline {
+		if act.at.After(now) {
+			break
+		}
+		if a.fired[act.key()] {
+			continue
+		}
+		a.fired[act.key()] = true
+
+		// Events due during a playback are late since it ended.
+		due := act.event.Time
+		if !a.playedUntil.IsZero() && due.Before(a.playedUntil) {
+			due = a.playedUntil
+		}
+		if late := now.Sub(due); late > a.CatchUp() {
+			// Only log the events missed since the first run e.g. during a
+			// restart, not the past events of a first run.
+			if !lastRun.IsZero() && act.kind == PLAY_ACTION {
+				log.Printf("Missed the %v of %v at %v by %v.", act.event.kind, act.event.Name, act.event.Time.Format(prayertimes.TIME_LAYOUT), late)
+			}
+			continue
+		}
+
+		switch act.kind {
+		case SWITCH_ON_ACTION:
+			if err := a.switchOn(ctx); err != nil {
+				if ctx.Err() != nil {
+					return 0, err
+				}
+				log.Printf("Warning: %v", err)
+				a.switchFailed = act.event.key()
+				continue
+			}
+			a.switchFailed = ""
+			warming = true
+		case PLAY_ACTION:
+			if a.switchFailed == act.event.key() {
+				log.Printf("Playing the %v of %v without switching the speakers on.", act.event.kind, act.event.Name)
+			} else if !a.switchedOn {
+				if err := a.switchOn(ctx); err != nil {
+					if ctx.Err() != nil {
+						return 0, err
+					}
+					// The Adhan still plays locally e.g. on speakers that
+					// are on or while homeassistant restarts.
+					log.Printf("Warning: %v. Playing the %v of %v anyway.", err, act.event.kind, act.event.Name)
+				} else {
+					warming = true
+				}
+			}
+			if warming {
+				// give chance for the speaker to turn on before playing.
+				if err := clock.Sleep(ctx, a.clock, *a.speakerPause); err != nil {
+					// Fires on the next run, within the catch-up window.
+					delete(a.fired, act.key())
+					return 0, err
+				}
+			}
+			// Saved before playing, so a crash while playing doesn't replay.
+			a.lastPlayed = act.event.key()
+			a.playedUntil = now
+			a.saveState()
+			if err := a.player(act.event.kind).Play(ctx); err != nil {
+				return 0, fmt.Errorf("error playing the %v of %v: %w", act.event.kind, act.event.Name, err)
+			}
+			return PLAYBACK_POLL, nil
+		}
+	}
+
+	var timeToNext time.Duration
+	if next, ok := a.nextAction(timeline, now); ok {
+		timeToNext = next.at.Sub(now)
+		log.Printf("Time left till %v %v: %v", next.event.kind, next.event.Name, next.event.Time.Sub(now))
+	} else {
+		// The schedule ended e.g. after Ishaa of a timetable's last day.
+		// The prayer times are reloaded for the next day.
+		midnight := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, now.Location())
+		timeToNext = midnight.Sub(now)
+		log.Printf("No actions left after %v. Reloading the prayer times at midnight.", now.Format(prayertimes.TIME_LAYOUT))
+	}
+
+	// Turn off the speakers unless the next event follows shortly.
+	if !a.switchedOff && timeToNext > SWITCH_OFF_GAP {
+		if _, err := a.homeassistant.TurnSwitchOff(ctx); httpclient.IsTransient(err) {
+			log.Printf("Warning: %v. Retrying in %v.", err, TRANSIENT_RETRY)
+			timeToNext = TRANSIENT_RETRY
+		} else if err != nil {
+			return 0, fmt.Errorf("error making a switch action: %w", err)
+		} else {
+			a.switchedOn, a.switchedOff = false, true
+		}
+	}
+	a.saveState()
+	return timeToNext, nil
+}
+
+// Shutdown stops the playback, switches the speakers off and saves the state.
+// ctx bounds the switch action e.g. to the docker stop timeout.
+func (a *Automation) Shutdown(ctx context.Context) error {
+	a.adhanPlayer.Stop()
+	for _, player := range a.players {
+		player.Stop()
+	}
+	a.saveState()
+
+	if a.switchedOff {
+		return nil
+	}
+	if _, err := a.homeassistant.TurnSwitchOff(ctx); err != nil {
+		return fmt.Errorf("error switching off the speakers during shutdown: %w", err)
+	}
+	a.switchedOn, a.switchedOff = false, true
+	return nil
+}
+
+// switchOn switches the speakers on, unless they are on.
+func (a *Automation) switchOn(ctx context.Context) error {
+	if a.switchedOn {
+		return nil
+	}
+	if _, err := a.homeassistant.TurnSwitchOn(ctx); err != nil {
+		return fmt.Errorf("error making a switch action: %w", err)
+	}
+	a.switchedOn, a.switchedOff = true, false
+	return nil
+}
+
+// nextAction returns the first action of the timeline after now that didn't
+// fire.
+func (a *Automation) nextAction(timeline []action, now time.Time) (action, bool) {
+	for _, act := range timeline {
+		if act.at.After(now) && !a.fired[act.key()] {
+			return act, true
+		}
+	}
+	return action{}, false
+}
+
+// forgetFired drops the fired actions that left the timeline.
+func (a *Automation) forgetFired(timeline []action) {
+	keys := map[string]bool{}
+	for _, act := range timeline {
+		keys[act.key()] = true
+	}
+	for key := range a.fired {
+		if !keys[key] {
+			delete(a.fired, key)
+		}
+	}
+}
+
+// ValidateAllActions turns on the speaker, play adhan and turns off the speakers afterwards.
+// It is skipped if the state file shows it already ran today e.g. on a restart.
+func (a *Automation) ValidateAllActions(ctx context.Context) error {
+	now := clock.OrSystem(a.clock).Now()
+	if a.fired == nil {
+		a.restoreState()
+	}
+	if a.validatedOn(now) {
+		log.Printf("Skipping the validation, it already ran at %v.", a.lastValidated)
+		return nil
+	}
+
+	if _, err := a.homeassistant.TurnSwitchOn(ctx); err != nil {
+		return fmt.Errorf("error validating all actions during TurnSwitchOn: %w", err)
+	}
+	a.switchedOn, a.switchedOff = true, false
+
+	// give chance for the speaker to turn on before playing.
+	if err := clock.Sleep(ctx, a.clock, *a.speakerPause); err != nil {
+		return err
+	}
+
+	// Saved before playing, so a crash while playing doesn't replay.
+	a.lastValidated = now
+	a.saveState()
+	if err := a.adhanPlayer.Play(ctx); err != nil {
+		return fmt.Errorf("error validating all actions during playing the Adhan: %w", err)
+	}
+
+	if err := clock.Sleep(ctx, a.clock, 20*time.Second); err != nil {
+		return err
+	}
+
+	if _, err := a.homeassistant.TurnSwitchOff(ctx); err != nil {
+		return fmt.Errorf("error validating all actions during TurnSwitchOff: %w", err)
+	}
+	a.switchedOn, a.switchedOff = false, true
+
+	return nil
+}
+
+// validatedOn returns True if ValidateAllActions ran on now's day, in the
+// timezone of the prayer times.
+func (a *Automation) validatedOn(now time.Time) bool {
+	if a.lastValidated.IsZero() {
+		return false
+	}
+	loc := now.Location()
+	if days := a.prayerTimes.GetDays(); len(days) > 0 {
+		loc = days[0].Date.Location()
+	}
+	y, m, d := a.lastValidated.In(loc).Date()
+	yy, mm, dd := now.In(loc).Date()
+	return y == yy && m == mm && d == dd
+}
@@ -0,0 +1,822 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package automation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/ssafty/adhan-homeassistant-pi/httpclient"
+	"github.com/ssafty/adhan-homeassistant-pi/prayertimes"
+)
+
+// Actions enum. Used by mocks to test the validity of the action sequences.
+const (
+	aPlay = 1 << iota
+	aIsPlaying
+
+	aTurnSwitchOn
+	aTurnSwitchOff
+
+	aStop
+)
+
+type adhanPlayerMock struct {
+	forcePlay    bool
+	actionLogger *[]int
+
+	isPlaying bool
+}
+
+func (a *adhanPlayerMock) Play(ctx context.Context) error {
+	a.isPlaying = true
+	*a.actionLogger = append(*a.actionLogger, aPlay)
+	return nil
+}
+
+func (a *adhanPlayerMock) IsPlaying() bool {
+	if a.forcePlay || a.isPlaying {
+		a.isPlaying = false
+		*a.actionLogger = append(*a.actionLogger, aIsPlaying)
+		return true
+	}
+	return false
+}
+
+func (a *adhanPlayerMock) Stop() {
+	if a.forcePlay || a.isPlaying {
+		a.forcePlay, a.isPlaying = false, false
+		*a.actionLogger = append(*a.actionLogger, aStop)
+	}
+}
+
+type homeassistantMock struct {
+	actionLogger *[]int
+
+	// err fails the switch actions, if set.
+	err error
+}
+
+func (h *homeassistantMock) TurnSwitchOn(ctx context.Context) (string, error) {
+	*h.actionLogger = append(*h.actionLogger, aTurnSwitchOn)
+	if h.err != nil {
+		return "", h.err
+	}
+	return "success", nil
+}
+
+func (h *homeassistantMock) TurnSwitchOff(ctx context.Context) (string, error) {
+	*h.actionLogger = append(*h.actionLogger, aTurnSwitchOff)
+	if h.err != nil {
+		return "", h.err
+	}
+	return "success", nil
+}
+
+type prayerTimesMock struct {
+	prayertimes.PrayerTimes
+
+	// iqama is published after each prayer, if non-zero.
+	iqama time.Duration
+	// lastDay is the last day covered, if non-zero e.g. of a timetable.
+	lastDay time.Time
+}
+
+func (p *prayerTimesMock) GetTodayPrayerTimes(ctx context.Context, now time.Time) error {
+	return p.Load(ctx, now, p)
+}
+
+func (p *prayerTimesMock) GetPrayerTimes(ctx context.Context, day time.Time) (*prayertimes.PrayerTimes, error) {
+	return p.PrayerTimesOn(ctx, day)
+}
+
+func (p *prayerTimesMock) PrayerTimesOn(ctx context.Context, day time.Time) (*prayertimes.PrayerTimes, error) {
+	if !p.lastDay.IsZero() && day.After(p.lastDay) {
+		return nil, fmt.Errorf("the timetable doesn't cover %v", day.Format(prayertimes.DATE_LAYOUT))
+	}
+	parse := func(s string) time.Time {
+		c, _ := time.Parse("15:04", s)
+		return time.Date(day.Year(), day.Month(), day.Day(), c.Hour(), c.Minute(), 0, 0, day.Location())
+	}
+
+	pt := &prayertimes.PrayerTimes{
+		Fajr:    &prayertimes.Prayer{Name: "Fajr", Time: parse("09:00")},
+		Sunrise: &prayertimes.Prayer{Name: "Sunrise", Time: parse("10:30")},
+		Dhuhr:   &prayertimes.Prayer{Name: "Dhuhr", Time: parse("12:00")},
+		Asr:     &prayertimes.Prayer{Name: "Asr", Time: parse("15:00")},
+		Maghrib: &prayertimes.Prayer{Name: "Maghrib", Time: parse("18:00")},
+		Ishaa:   &prayertimes.Prayer{Name: "Ishaa", Time: parse("21:00")},
+		Date:    prayertimes.GetDate(day),
+	}
+	if p.iqama != 0 {
+		for _, pr := range pt.Prayers() {
+			pr.Iqama = pr.Time.Add(p.iqama)
+		}
+	}
+	return pt, nil
+}
+
+func TestRunAndSleep(t *testing.T) {
+	parse := func(s string) time.Time {
+		c, err := time.Parse("15:04", s)
+		if err != nil {
+			t.Fatalf("Failed to parse the time %v: %v", s, err)
+		}
+		return c
+	}
+
+	for _, test := range []struct {
+		description string
+		forcePlay   bool
+		now         time.Time
+
+		wantSleepDuration  time.Duration
+		wantActionSequence []int
+	}{
+		{
+			description: "Adhan currently playing should send automation to sleep",
+			forcePlay:   true,
+
+			wantSleepDuration:  PLAYBACK_POLL,
+			wantActionSequence: []int{aIsPlaying},
+		},
+		{
+			description: "Fajr time should turnSwitchOn and play",
+			now:         parse("09:00"),
+
+			wantSleepDuration:  PLAYBACK_POLL,
+			wantActionSequence: []int{aTurnSwitchOn, aPlay},
+		},
+		{
+			description: "1 minutes after Dhuhr should turnSwitchOn and play",
+			now:         parse("12:01"),
+
+			wantSleepDuration:  PLAYBACK_POLL,
+			wantActionSequence: []int{aTurnSwitchOn, aPlay},
+		},
+		{
+			description: "10 minutes after Dhuhr should turnSwitchOff and Sleep",
+			now:         parse("12:10"),
+
+			// Sleep from 12:10 to 15:00 (Asr)
+			wantSleepDuration:  time.Minute*50 + time.Hour*2,
+			wantActionSequence: []int{aTurnSwitchOff},
+		},
+		{
+			description: "5 minutes before Asr time should keep the speakers and sleep till Asr",
+			now:         parse("14:55"),
+
+			wantSleepDuration:  FIVE_MINUTES,
+			wantActionSequence: []int{},
+		},
+		{
+			description: "7 minutes before Asr time should turnSwitchOff and sleep till Asr",
+			now:         parse("14:53"),
+
+			wantSleepDuration:  7 * time.Minute,
+			wantActionSequence: []int{aTurnSwitchOff},
+		},
+	} {
+		t.Run(test.description, func(t *testing.T) {
+			actions := []int{}
+			a := Automation{
+				adhanPlayer:   &adhanPlayerMock{forcePlay: test.forcePlay, actionLogger: &actions},
+				homeassistant: &homeassistantMock{actionLogger: &actions},
+				prayerTimes:   &prayerTimesMock{},
+				speakerPause:  func() *time.Duration { d := time.Duration(0 * time.Second); return &d }()}
+
+			sleepDuration, err := a.RunAndSleep(context.Background(), test.now)
+			if err != nil {
+				t.Errorf("RunAndSleep expects no error. Got %v", err)
+			}
+			if sleepDuration != test.wantSleepDuration {
+				t.Errorf("RunAndSleep sleep duration mismatch. Got %v, want %v", sleepDuration, test.wantSleepDuration)
+			}
+			if !cmp.Equal(actions, test.wantActionSequence) {
+				t.Errorf("RunAndSleep sleep action sequence mismatch. Got %v, want %v", actions, test.wantActionSequence)
+			}
+		})
+	}
+}
+
+func TestRunAndSleepEndOfSchedule(t *testing.T) {
+	actions := []int{}
+	lastDay := time.Date(2024, time.March, 14, 12, 0, 0, 0, time.UTC)
+	a := Automation{
+		adhanPlayer:   &adhanPlayerMock{actionLogger: &actions},
+		homeassistant: &homeassistantMock{actionLogger: &actions},
+		prayerTimes:   &prayerTimesMock{lastDay: lastDay},
+		speakerPause:  func() *time.Duration { d := time.Duration(0 * time.Second); return &d }()}
+
+	// Tomorrow isn't covered, so nothing follows Ishaa at 21:00.
+	sleepDuration, err := a.RunAndSleep(context.Background(), time.Date(2024, time.March, 14, 21, 10, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("RunAndSleep expects no error. Got %v", err)
+	}
+	if want := 2*time.Hour + 50*time.Minute; sleepDuration != want {
+		t.Errorf("RunAndSleep should sleep until midnight. Got %v, want %v", sleepDuration, want)
+	}
+	if want := []int{aTurnSwitchOff}; !cmp.Equal(actions, want) {
+		t.Errorf("RunAndSleep action sequence mismatch. Got %v, want %v", actions, want)
+	}
+}
+
+// TestRunAndSleepIntegration emulates an entire day (+spillover) of decision making.
+func TestRunAndSleepIntegration(t *testing.T) {
+	parse := func(s string) time.Time {
+		c, err := time.Parse("15:04", s)
+		if err != nil {
+			t.Fatalf("Failed to parse the time %v: %v", s, err)
+		}
+		return c
+	}
+
+	startingTime := parse("01:04")
+	maxActions := 22
+
+	gotActions := []int{}
+	gotTotalSleep := time.Minute * 0
+
+	a := Automation{
+		adhanPlayer:   &adhanPlayerMock{isPlaying: false, actionLogger: &gotActions},
+		homeassistant: &homeassistantMock{actionLogger: &gotActions},
+		prayerTimes:   &prayerTimesMock{},
+		speakerPause:  func() *time.Duration { d := time.Duration(0 * time.Second); return &d }()}
+
+	for i := 0; i < maxActions; i++ {
+		sleepDuration, err := a.RunAndSleep(context.Background(), startingTime)
+		if err != nil {
+			t.Errorf("RunAndSleep expects no error. Got %v", err)
+		}
+
+		gotTotalSleep += sleepDuration
+		startingTime = startingTime.Add(sleepDuration)
+	}
+
+	if wantActionSequence := []int{aTurnSwitchOff,
+		aTurnSwitchOn, aPlay, aIsPlaying, aTurnSwitchOff,
+		aTurnSwitchOn, aPlay, aIsPlaying, aTurnSwitchOff,
+		aTurnSwitchOn, aPlay, aIsPlaying, aTurnSwitchOff,
+		aTurnSwitchOn, aPlay, aIsPlaying, aTurnSwitchOff,
+		aTurnSwitchOn, aPlay, aIsPlaying, aTurnSwitchOff,
+		// next day
+		aTurnSwitchOn, aPlay, aIsPlaying, aTurnSwitchOff,
+		aTurnSwitchOn, aPlay, aIsPlaying, aTurnSwitchOff,
+	}; !cmp.Equal(gotActions, wantActionSequence) {
+		t.Errorf("RunAndSleep sleep action sequence mismatch. Got %v, want %v", gotActions, wantActionSequence)
+	}
+
+	// sleep(7h56min) = fajr time - time.now("01:04").
+	// At each prayer, sleep(5 seconds) twice till the Adhan is done playing.
+	// Then sleep till the next prayer e.g. sleep(2h59min50s) till Dhuhr. At
+	// Ishaa, sleep till tomorrow's Fajr. Next day, repeat for two more
+	// prayers, ending at Asr as we reached our decisions limit.
+	if want := 37*time.Hour + 56*time.Minute; gotTotalSleep != want {
+		t.Errorf("RunAndSleep total sleep duration mismatch. Got %v, want %v", gotTotalSleep, want)
+	}
+}
+
+func TestRunAndSleepChimes(t *testing.T) {
+	parse := func(s string) time.Time {
+		c, err := time.Parse("15:04", s)
+		if err != nil {
+			t.Fatalf("Failed to parse the time %v: %v", s, err)
+		}
+		return c
+	}
+
+	adhanActions := []int{}
+	chimeActions := []int{}
+	switchActions := []int{}
+	a := Automation{
+		adhanPlayer:   &adhanPlayerMock{actionLogger: &adhanActions},
+		homeassistant: &homeassistantMock{actionLogger: &switchActions},
+		prayerTimes:   &prayerTimesMock{},
+		speakerPause:  func() *time.Duration { d := time.Duration(0 * time.Second); return &d }(),
+	}
+	Chimes(&adhanPlayerMock{actionLogger: &chimeActions}, "sunrise")(&a)
+
+	for _, test := range []struct {
+		description string
+		now         time.Time
+
+		wantSleepDuration time.Duration
+		wantSwitchActions []int
+		wantChimeActions  []int
+	}{
+		{
+			description: "After Fajr should sleep till sunrise",
+			now:         parse("09:10"),
+
+			wantSleepDuration: time.Hour + 20*time.Minute,
+			wantSwitchActions: []int{aTurnSwitchOff},
+			wantChimeActions:  []int{},
+		},
+		{
+			description: "Sunrise should play the chime",
+			now:         parse("10:30"),
+
+			wantSleepDuration: PLAYBACK_POLL,
+			wantSwitchActions: []int{aTurnSwitchOn},
+			wantChimeActions:  []int{aPlay},
+		},
+		{
+			description: "Chime is playing",
+			now:         parse("10:30"),
+
+			wantSleepDuration: PLAYBACK_POLL,
+			wantSwitchActions: []int{},
+			wantChimeActions:  []int{aIsPlaying},
+		},
+		{
+			description: "1 minute after sunrise should not repeat the chime",
+			now:         parse("10:31"),
+
+			wantSleepDuration: time.Hour + 29*time.Minute,
+			wantSwitchActions: []int{aTurnSwitchOff},
+			wantChimeActions:  []int{},
+		},
+	} {
+		t.Run(test.description, func(t *testing.T) {
+			switchActions = switchActions[:0]
+			chimeActions = chimeActions[:0]
+
+			sleepDuration, err := a.RunAndSleep(context.Background(), test.now)
+			if err != nil {
+				t.Errorf("RunAndSleep expects no error. Got %v", err)
+			}
+			if sleepDuration != test.wantSleepDuration {
+				t.Errorf("RunAndSleep sleep duration mismatch. Got %v, want %v", sleepDuration, test.wantSleepDuration)
+			}
+			if !cmp.Equal(switchActions, test.wantSwitchActions) {
+				t.Errorf("RunAndSleep switch action sequence mismatch. Got %v, want %v", switchActions, test.wantSwitchActions)
+			}
+			if !cmp.Equal(chimeActions, test.wantChimeActions) {
+				t.Errorf("RunAndSleep chime action sequence mismatch. Got %v, want %v", chimeActions, test.wantChimeActions)
+			}
+		})
+	}
+	if len(adhanActions) != 0 {
+		t.Errorf("RunAndSleep should not play the Adhan at sunrise. Got actions %v", adhanActions)
+	}
+}
+
+func TestRunAndSleepEvents(t *testing.T) {
+	for _, test := range []struct {
+		description string
+		// mode defaults to RAMADAN_OFF.
+		mode RamadanMode
+		// published iqama after each prayer, if non-zero.
+		published time.Duration
+		now       time.Time
+
+		// wantPlayer is the only player that plays, if any.
+		wantPlayer string
+	}{
+		{
+			description: "Suhoor alert 30 minutes before Fajr on 1 Ramadan",
+			mode:        RAMADAN_AUTO,
+			now:         time.Date(2024, time.March, 11, 8, 30, 0, 0, time.UTC),
+			wantPlayer:  "suhoor",
+		},
+		{
+			description: "Iftar at Maghrib on 1 Ramadan",
+			mode:        RAMADAN_AUTO,
+			now:         time.Date(2024, time.March, 11, 18, 0, 0, 0, time.UTC),
+			wantPlayer:  "iftar",
+		},
+		{
+			description: "Maghrib Adhan on the last day of Sha'ban",
+			mode:        RAMADAN_AUTO,
+			now:         time.Date(2024, time.March, 10, 18, 0, 0, 0, time.UTC),
+			wantPlayer:  "adhan",
+		},
+		{
+			description: "No Suhoor alert on Eid al-Fitr",
+			mode:        RAMADAN_AUTO,
+			now:         time.Date(2024, time.April, 10, 8, 30, 0, 0, time.UTC),
+		},
+		{
+			description: "Manual override outside of Ramadan",
+			mode:        RAMADAN_ON,
+			now:         time.Date(2024, time.April, 10, 18, 0, 0, 0, time.UTC),
+			wantPlayer:  "iftar",
+		},
+		{
+			description: "Switched off in Ramadan",
+			mode:        RAMADAN_OFF,
+			now:         time.Date(2024, time.March, 11, 18, 0, 0, 0, time.UTC),
+			wantPlayer:  "adhan",
+		},
+		{
+			description: "Surah al-Kahf reminder on Friday morning",
+			now:         time.Date(2024, time.March, 15, 10, 0, 0, 0, time.UTC),
+			wantPlayer:  "kahf",
+		},
+		{
+			description: "Jumu'ah reminder 45 minutes before Dhuhr",
+			now:         time.Date(2024, time.March, 15, 11, 15, 0, 0, time.UTC),
+			wantPlayer:  "reminder",
+		},
+		{
+			description: "Jumu'ah Adhan at Dhuhr",
+			now:         time.Date(2024, time.March, 15, 12, 0, 0, 0, time.UTC),
+			wantPlayer:  "jumuah",
+		},
+		{
+			description: "No reminder on Thursday",
+			now:         time.Date(2024, time.March, 14, 11, 15, 0, 0, time.UTC),
+		},
+		{
+			description: "Dhuhr Adhan on Thursday",
+			now:         time.Date(2024, time.March, 14, 12, 0, 0, 0, time.UTC),
+			wantPlayer:  "adhan",
+		},
+		{
+			description: "Adhan at Fajr",
+			now:         time.Date(2024, time.March, 14, 9, 0, 0, 0, time.UTC),
+			wantPlayer:  "adhan",
+		},
+		{
+			description: "Iqama 20 minutes after Fajr",
+			now:         time.Date(2024, time.March, 14, 9, 20, 0, 0, time.UTC),
+			wantPlayer:  "iqama",
+		},
+		{
+			description: "Iqama 5 minutes after Maghrib",
+			now:         time.Date(2024, time.March, 14, 18, 5, 0, 0, time.UTC),
+			wantPlayer:  "iqama",
+		},
+		{
+			description: "No iqama without a delay",
+			now:         time.Date(2024, time.March, 14, 12, 15, 0, 0, time.UTC),
+		},
+		{
+			description: "Published iqama without a delay",
+			published:   15 * time.Minute,
+			now:         time.Date(2024, time.March, 14, 12, 15, 0, 0, time.UTC),
+			wantPlayer:  "iqama",
+		},
+		{
+			description: "Delay overrides the published iqama",
+			published:   15 * time.Minute,
+			now:         time.Date(2024, time.March, 14, 9, 15, 0, 0, time.UTC),
+		},
+	} {
+		t.Run(test.description, func(t *testing.T) {
+			actions := map[string]*[]int{}
+			player := func(name string) IAdhanPlayer {
+				actions[name] = &[]int{}
+				return &adhanPlayerMock{actionLogger: actions[name]}
+			}
+			a := Automation{
+				adhanPlayer:   player("adhan"),
+				homeassistant: &homeassistantMock{actionLogger: &[]int{}},
+				prayerTimes:   &prayerTimesMock{iqama: test.published},
+				speakerPause:  func() *time.Duration { d := time.Duration(0 * time.Second); return &d }(),
+			}
+			mode := test.mode
+			if mode == "" {
+				mode = RAMADAN_OFF
+			}
+			for _, opt := range []AutomationOpt{
+				Ramadan(mode),
+				Suhoor(player("suhoor"), 30*time.Minute),
+				Iftar(player("iftar")),
+				JumuahAdhan(player("jumuah")),
+				JumuahReminder(player("reminder"), 45*time.Minute),
+				KahfReminder(player("kahf"), 10*time.Hour),
+				Iqama(player("iqama"), map[string]time.Duration{"Fajr": 20 * time.Minute, "Maghrib": 5 * time.Minute}),
+			} {
+				opt(&a)
+			}
+
+			if _, err := a.RunAndSleep(context.Background(), test.now); err != nil {
+				t.Errorf("RunAndSleep expects no error. Got %v", err)
+			}
+			for name, got := range actions {
+				want := []int{}
+				if name == test.wantPlayer {
+					want = []int{aPlay}
+				}
+				if !cmp.Equal(*got, want) {
+					t.Errorf("RunAndSleep %v action sequence mismatch. Got %v, want %v", name, *got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRunAndSleepQueuedDuringPlayback(t *testing.T) {
+	at := func(min, sec int) time.Time {
+		return time.Date(2024, time.March, 14, 18, min, sec, 0, time.UTC)
+	}
+	adhanActions, iqamaActions := []int{}, []int{}
+	a := Automation{
+		adhanPlayer:   &adhanPlayerMock{actionLogger: &adhanActions},
+		homeassistant: &homeassistantMock{actionLogger: &[]int{}},
+		prayerTimes:   &prayerTimesMock{},
+		speakerPause:  func() *time.Duration { d := time.Duration(0 * time.Second); return &d }(),
+	}
+	Iqama(&adhanPlayerMock{actionLogger: &iqamaActions}, map[string]time.Duration{"Maghrib": time.Minute})(&a)
+
+	// The Adhan plays until 18:03, past the catch-up window of the iqama
+	// at 18:01.
+	for _, now := range []time.Time{at(0, 0), at(3, 0), at(3, 5)} {
+		if _, err := a.RunAndSleep(context.Background(), now); err != nil {
+			t.Fatalf("RunAndSleep expects no error. Got %v", err)
+		}
+	}
+	if want := []int{aPlay, aIsPlaying}; !cmp.Equal(adhanActions, want) {
+		t.Errorf("RunAndSleep adhan action sequence mismatch. Got %v, want %v", adhanActions, want)
+	}
+	if want := []int{aPlay}; !cmp.Equal(iqamaActions, want) {
+		t.Errorf("RunAndSleep should play the iqama due during the Adhan once it ends. Got %v, want %v", iqamaActions, want)
+	}
+}
+
+func TestRunAndSleepShortIqama(t *testing.T) {
+	from := time.Date(2024, time.March, 14, 17, 0, 0, 0, time.UTC)
+	sim := NewSimulation(from, 4*time.Minute)
+
+	pause := 10 * time.Second
+	a, err := NewAutomation(sim.Player("adhan.mp3"), sim.Switch(), &prayerTimesMock{},
+		SpeakerPause(&pause), AutomationClock(sim.Clock()),
+		Iqama(sim.Player("iqama.mp3"), map[string]time.Duration{"Maghrib": time.Minute}))
+	if err != nil {
+		t.Fatalf("NewAutomation returned error, expected None: %v", err)
+	}
+	if err := sim.Run(context.Background(), a, time.UTC, from.Add(2*time.Hour)); err != nil {
+		t.Fatalf("run returned error, expected None: %v", err)
+	}
+
+	// The iqama at 18:01 plays once the 4 minutes Adhan ended and after its
+	// speaker pause.
+	plays := []string{}
+	for _, r := range sim.records {
+		if r.kind == SIMULATED_PLAY {
+			plays = append(plays, r.at.Format("15:04:05")+" "+r.player)
+		}
+	}
+	want := []string{"18:00:00 adhan.mp3", "18:04:10 iqama.mp3"}
+	if !cmp.Equal(plays, want) {
+		t.Errorf("Played events mismatch. Got %v, want %v", plays, want)
+	}
+}
+
+func TestNewAutomation(t *testing.T) {
+	for _, test := range []struct {
+		description string
+		ha          IHomeAssistant
+		ap          IAdhanPlayer
+		pt          prayertimes.IPrayerTimes
+		pause       time.Duration
+		chimes      []string
+		ramadan     RamadanMode
+		iqama       map[string]time.Duration
+		catchUp     time.Duration
+	}{
+		{
+			description: "Homeassistant is missing",
+			ap:          &adhanPlayerMock{},
+			pt:          &prayerTimesMock{},
+			pause:       time.Second,
+		},
+		{
+			description: "AdhanPlayer is missing",
+			ha:          &homeassistantMock{},
+			pt:          &prayerTimesMock{},
+			pause:       time.Second,
+		},
+		{
+			description: "MunichPrayerTimes is missing",
+			ap:          &adhanPlayerMock{},
+			ha:          &homeassistantMock{},
+			pause:       time.Second,
+		},
+		{
+			description: "Speaker pause is missing",
+			ap:          &adhanPlayerMock{},
+			pt:          &prayerTimesMock{},
+			ha:          &homeassistantMock{},
+		},
+		{
+			description: "Unknown chime",
+			ap:          &adhanPlayerMock{},
+			pt:          &prayerTimesMock{},
+			ha:          &homeassistantMock{},
+			pause:       time.Second,
+			chimes:      []string{"noon"},
+		},
+		{
+			description: "Unknown Ramadan mode",
+			ap:          &adhanPlayerMock{},
+			pt:          &prayerTimesMock{},
+			ha:          &homeassistantMock{},
+			pause:       time.Second,
+			ramadan:     "sometimes",
+		},
+		{
+			description: "Iqama delay of an unknown prayer",
+			ap:          &adhanPlayerMock{},
+			pt:          &prayerTimesMock{},
+			ha:          &homeassistantMock{},
+			pause:       time.Second,
+			iqama:       map[string]time.Duration{"Isha": 10 * time.Minute},
+		},
+		{
+			description: "Iqama delay is negative",
+			ap:          &adhanPlayerMock{},
+			pt:          &prayerTimesMock{},
+			ha:          &homeassistantMock{},
+			pause:       time.Second,
+			iqama:       map[string]time.Duration{"Fajr": -10 * time.Minute},
+		},
+		{
+			description: "Catch-up window is shorter than a timer",
+			ap:          &adhanPlayerMock{},
+			pt:          &prayerTimesMock{},
+			ha:          &homeassistantMock{},
+			pause:       time.Second,
+			catchUp:     time.Second,
+		},
+		{
+			description: "Speaker pause is negative",
+			ap:          &adhanPlayerMock{},
+			pt:          &prayerTimesMock{},
+			ha:          &homeassistantMock{},
+			pause:       -1 * time.Second,
+		},
+	} {
+		t.Run(test.description, func(t *testing.T) {
+			opts := []AutomationOpt{Chimes(&adhanPlayerMock{}, test.chimes...)}
+			if test.ramadan != "" {
+				opts = append(opts, Ramadan(test.ramadan))
+			}
+			if test.pause != 0 {
+				opts = append(opts, SpeakerPause(&test.pause))
+			}
+			if test.catchUp != 0 {
+				opts = append(opts, CatchUpWindow(test.catchUp))
+			}
+			if test.iqama != nil {
+				opts = append(opts, Iqama(&adhanPlayerMock{}, test.iqama))
+			}
+			if _, err := NewAutomation(test.ap, test.ha, test.pt, opts...); err == nil {
+				t.Errorf("NewAutomation expected an error on init. Got none.")
+			}
+		})
+	}
+}
+
+func TestRunAndSleepSwitchErrors(t *testing.T) {
+	at := func(hour, min int) time.Time {
+		return time.Date(2024, time.March, 14, hour, min, 0, 0, time.UTC)
+	}
+	transient := httpclient.Transient(errors.New("502 Bad Gateway"))
+	permanent := errors.New("401 Unauthorized")
+
+	for _, test := range []struct {
+		description string
+		err         error
+		now         time.Time
+
+		wantActions []int
+		wantSleep   time.Duration
+		wantErr     bool
+	}{
+		{
+			description: "Adhan plays locally if switching on fails transiently",
+			err:         transient,
+			now:         at(9, 0),
+			wantActions: []int{aTurnSwitchOn, aPlay},
+			wantSleep:   PLAYBACK_POLL,
+		},
+		{
+			description: "Adhan plays locally if switching on fails permanently",
+			err:         permanent,
+			now:         at(9, 0),
+			wantActions: []int{aTurnSwitchOn, aPlay},
+			wantSleep:   PLAYBACK_POLL,
+		},
+		{
+			description: "Switching off is retried after a transient error",
+			err:         transient,
+			now:         at(10, 0),
+			wantActions: []int{aTurnSwitchOff},
+			wantSleep:   TRANSIENT_RETRY,
+		},
+		{
+			description: "Switching off fails after a permanent error",
+			err:         permanent,
+			now:         at(10, 0),
+			wantActions: []int{aTurnSwitchOff},
+			wantErr:     true,
+		},
+	} {
+		t.Run(test.description, func(t *testing.T) {
+			actions := []int{}
+			pause := time.Duration(0)
+			a := &Automation{
+				adhanPlayer:   &adhanPlayerMock{actionLogger: &actions},
+				homeassistant: &homeassistantMock{actionLogger: &actions, err: test.err},
+				prayerTimes:   &prayerTimesMock{},
+				speakerPause:  &pause,
+			}
+
+			sleep, err := a.RunAndSleep(context.Background(), test.now)
+			if gotErr := err != nil; gotErr != test.wantErr {
+				t.Fatalf("RunAndSleep error mismatch. Got %v, want error: %v", err, test.wantErr)
+			}
+			if !cmp.Equal(actions, test.wantActions) {
+				t.Errorf("RunAndSleep action sequence mismatch. Got %v, want %v", actions, test.wantActions)
+			}
+			if err == nil && sleep != test.wantSleep {
+				t.Errorf("RunAndSleep sleep duration mismatch. Got %v, want %v", sleep, test.wantSleep)
+			}
+		})
+	}
+}
+
+func TestRunAndSleepSwitchOnFailedBeforePlay(t *testing.T) {
+	actions := []int{}
+	pause := 10 * time.Second
+	a := &Automation{
+		adhanPlayer:   &adhanPlayerMock{actionLogger: &actions},
+		homeassistant: &homeassistantMock{actionLogger: &actions, err: errors.New("401 Unauthorized")},
+		prayerTimes:   &prayerTimesMock{},
+		speakerPause:  &pause,
+	}
+
+	// The switch-on before Fajr fails, so Fajr plays right away without
+	// retrying it.
+	fajr := time.Date(2024, time.March, 14, 9, 0, 0, 0, time.UTC)
+	for _, now := range []time.Time{fajr.Add(-pause), fajr} {
+		if _, err := a.RunAndSleep(context.Background(), now); err != nil {
+			t.Fatalf("RunAndSleep expects no error. Got %v", err)
+		}
+	}
+	if want := []int{aTurnSwitchOn, aPlay}; !cmp.Equal(actions, want) {
+		t.Errorf("RunAndSleep action sequence mismatch. Got %v, want %v", actions, want)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	for _, test := range []struct {
+		description string
+		playing     bool
+		switchedOff bool
+
+		wantActions []int
+	}{
+		{
+			description: "Stops the playback and switches the speakers off",
+			playing:     true,
+			wantActions: []int{aStop, aTurnSwitchOff},
+		},
+		{
+			description: "Switches the speakers off while idle",
+			wantActions: []int{aTurnSwitchOff},
+		},
+		{
+			description: "Speakers are already switched off",
+			switchedOff: true,
+			wantActions: []int{},
+		},
+	} {
+		t.Run(test.description, func(t *testing.T) {
+			actions := []int{}
+			a := &Automation{
+				adhanPlayer:   &adhanPlayerMock{actionLogger: &actions, isPlaying: test.playing},
+				homeassistant: &homeassistantMock{actionLogger: &actions},
+				players:       map[eventKind]IAdhanPlayer{CHIME_EVENT: &adhanPlayerMock{actionLogger: &actions}},
+				switchedOff:   test.switchedOff,
+			}
+			if err := a.Shutdown(context.Background()); err != nil {
+				t.Fatalf("Shutdown expects no error. Got %v", err)
+			}
+			if !cmp.Equal(actions, test.wantActions) {
+				t.Errorf("Shutdown action sequence mismatch. Got %v, want %v", actions, test.wantActions)
+			}
+			if !a.switchedOff {
+				t.Errorf("Shutdown should leave the speakers switched off.")
+			}
+		})
+	}
+}
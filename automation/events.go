@@ -0,0 +1,196 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// events are the times the automation acts on: the Adhan of each prayer and
+// optional chimes, Suhoor alerts, Iftar announcements and Friday reminders.
+
+package automation
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ssafty/adhan-homeassistant-pi/prayertimes"
+)
+
+// eventKind selects the player of an event.
+type eventKind string
+
+const (
+	ADHAN_EVENT eventKind = "adhan"
+	// CHIME_EVENT marks non-adhan times e.g. sunrise with a soft chime.
+	CHIME_EVENT eventKind = "chime"
+	// SUHOOR_EVENT alerts the end of Suhoor before Fajr in Ramadan.
+	SUHOOR_EVENT eventKind = "suhoor"
+	// IFTAR_EVENT replaces the Maghrib Adhan in Ramadan.
+	IFTAR_EVENT eventKind = "iftar"
+	// JUMUAH_EVENT replaces the Dhuhr Adhan on Fridays.
+	JUMUAH_EVENT eventKind = "jumuah"
+	// JUMUAH_REMINDER_EVENT reminds of Jumu'ah before Dhuhr on Fridays.
+	JUMUAH_REMINDER_EVENT eventKind = "jumuah reminder"
+	// KAHF_REMINDER_EVENT reminds to read Surah al-Kahf on Friday mornings.
+	KAHF_REMINDER_EVENT eventKind = "kahf reminder"
+	// IQAMA_EVENT calls the iqama of a prayer after its Adhan.
+	IQAMA_EVENT eventKind = "iqama"
+)
+
+// IQAMA_PRAYERS are the prayer names of the iqama delays.
+var IQAMA_PRAYERS = []string{"Fajr", "Dhuhr", "Asr", "Maghrib", "Ishaa"}
+
+// CHIME_EVENTS are the names of the times that can be chimed.
+var CHIME_EVENTS = []string{"sunrise", "duha", "midnight", "last_third"}
+
+// RamadanMode switches the Suhoor and Iftar events on.
+type RamadanMode string
+
+const (
+	RAMADAN_OFF RamadanMode = "off"
+	// RAMADAN_AUTO follows the Hijri date of the prayer times.
+	RAMADAN_AUTO RamadanMode = "auto"
+	RAMADAN_ON   RamadanMode = "on"
+)
+
+// RAMADAN is the month of the Hijri calendar.
+const RAMADAN = 9
+
+// eventRule adds an event to the days of a weekday e.g. a reminder before
+// Jumu'ah on Fridays.
+type eventRule struct {
+	weekday time.Weekday
+	kind    eventKind
+	name    string
+	// at returns the time of the event on a day.
+	at func(day *prayertimes.PrayerTimes) time.Time
+}
+
+// event is a prayer time the automation acts on.
+type event struct {
+	*prayertimes.Prayer
+	kind eventKind
+}
+
+// key identifies an event across reloads of the prayer times.
+func (e event) key() string {
+	return fmt.Sprintf("%v %v at %v", e.kind, e.Name, e.Time.Format(time.RFC3339))
+}
+
+// isIqamaPrayer returns True if name is one of IQAMA_PRAYERS.
+func isIqamaPrayer(name string) bool {
+	for _, p := range IQAMA_PRAYERS {
+		if p == name {
+			return true
+		}
+	}
+	return false
+}
+
+// isChimeEvent returns True if name is one of CHIME_EVENTS.
+func isChimeEvent(name string) bool {
+	for _, c := range CHIME_EVENTS {
+		if c == name {
+			return true
+		}
+	}
+	return false
+}
+
+// chimeName maps the name of an extra time to its CHIME_EVENTS name e.g.
+// "Last third" to "last_third".
+func chimeName(name string) string {
+	return strings.ToLower(strings.ReplaceAll(name, " ", "_"))
+}
+
+// isRamadan returns True if the Ramadan events are enabled on a day.
+func (a *Automation) isRamadan(day *prayertimes.PrayerTimes) bool {
+	switch a.ramadan {
+	case RAMADAN_ON:
+		return true
+	case RAMADAN_AUTO:
+		return day.GetHijriDate().Month() == RAMADAN
+	default:
+		return false
+	}
+}
+
+// events returns the events of the days loaded by the prayer times sorted by
+// time.
+func (a *Automation) events() []event {
+	events := []event{}
+	for _, day := range a.prayerTimes.GetDays() {
+		ramadan := a.isRamadan(day)
+		friday := day.Date.Weekday() == time.Friday
+
+		for _, p := range day.Prayers() {
+			kind := ADHAN_EVENT
+			switch {
+			case ramadan && p == day.Maghrib && a.players[IFTAR_EVENT] != nil:
+				kind = IFTAR_EVENT
+			case friday && p == day.Dhuhr && a.players[JUMUAH_EVENT] != nil:
+				kind = JUMUAH_EVENT
+			}
+			events = append(events, event{p, kind})
+		}
+		if a.players[IQAMA_EVENT] != nil {
+			events = append(events, a.iqamaEvents(day)...)
+		}
+		for _, rule := range a.rules {
+			if day.Date.Weekday() == rule.weekday {
+				events = append(events, event{&prayertimes.Prayer{Name: rule.name, Time: rule.at(day)}, rule.kind})
+			}
+		}
+		for _, p := range day.Extras() {
+			if a.chimes[chimeName(p.Name)] {
+				events = append(events, event{p, CHIME_EVENT})
+			}
+		}
+		if ramadan && a.players[SUHOOR_EVENT] != nil {
+			suhoor := &prayertimes.Prayer{Name: "Suhoor", Time: day.Fajr.Time.Add(-a.suhoorWarning)}
+			events = append(events, event{suhoor, SUHOOR_EVENT})
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Time.Before(events[j].Time) })
+	return events
+}
+
+// iqamaEvents returns the iqama of a day's prayers, a configured delay after
+// the Adhan or else at the iqama published by the prayer times, if any. The
+// Asr delay applies to both Asr prayers.
+func (a *Automation) iqamaEvents(day *prayertimes.PrayerTimes) []event {
+	events := []event{}
+	for _, pr := range []struct {
+		name   string
+		prayer *prayertimes.Prayer
+	}{
+		{"Fajr", day.Fajr},
+		{"Dhuhr", day.Dhuhr},
+		{"Asr", day.Asr},
+		{"Asr", day.SecondAsr},
+		{"Maghrib", day.Maghrib},
+		{"Ishaa", day.Ishaa},
+	} {
+		if pr.prayer == nil {
+			continue
+		}
+		iqama := pr.prayer.Iqama
+		if delay, ok := a.iqamaDelays[pr.name]; ok {
+			iqama = pr.prayer.Time.Add(delay)
+		}
+		if !iqama.IsZero() {
+			events = append(events, event{&prayertimes.Prayer{Name: pr.prayer.Name, Time: iqama}, IQAMA_EVENT})
+		}
+	}
+	return events
+}
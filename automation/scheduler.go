@@ -0,0 +1,186 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Event-driven scheduler. The automation turns the events of the prayer times
+// into a timeline of actions: switching the speakers on a speaker pause before
+// an event (pre-warm), playing it and switching them off once the audio ended
+// (post-off). The scheduler waits for the next action with timers.
+//
+// Timers follow the monotonic clock, which stops while the system is suspended
+// and ignores changes of the wall clock e.g. by NTP. Waits are therefore split
+// into timers of at most MAX_TIMER and re-armed against the wall clock, and
+// every action fires once, keyed by its event.
+
+package automation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/ssafty/adhan-homeassistant-pi/clock"
+	"github.com/ssafty/adhan-homeassistant-pi/httpclient"
+)
+
+const (
+	// MAX_TIMER bounds the timers so a suspend or a clock change delays an
+	// action by at most MAX_TIMER. It is the shortest catch-up window.
+	MAX_TIMER = ONE_MINUTE
+	// DEFAULT_CATCH_UP_WINDOW is how late an event may still play e.g. after
+	// a suspend or a restart. Older events are skipped.
+	DEFAULT_CATCH_UP_WINDOW = TWO_MINUTES
+	// PLAYBACK_POLL is the interval to check whether the audio ended.
+	PLAYBACK_POLL = FIVE_SECONDS
+	// SWITCH_OFF_GAP keeps the speakers on if the next event follows within
+	// the gap e.g. the iqama after the Adhan.
+	SWITCH_OFF_GAP = FIVE_MINUTES
+	// CLOCK_JUMP is the smallest difference between the wall and monotonic
+	// clocks logged as a clock jump.
+	CLOCK_JUMP = FIVE_SECONDS
+	// TRANSIENT_RETRY is the time to retry the automation after a transient
+	// error e.g. while homeassistant restarts. It is shorter than
+	// SWITCH_OFF_GAP.
+	TRANSIENT_RETRY = ONE_MINUTE
+)
+
+// actionKind is a step of an event's timeline.
+type actionKind string
+
+const (
+	SWITCH_ON_ACTION actionKind = "switch on"
+	PLAY_ACTION      actionKind = "play"
+)
+
+// action is a step of the timeline at a wall clock time.
+type action struct {
+	kind  actionKind
+	at    time.Time
+	event event
+}
+
+// key identifies an action across reloads of the prayer times so it fires
+// once.
+func (a action) key() string {
+	return fmt.Sprintf("%v %v", a.kind, a.event.key())
+}
+
+// timeline returns the actions of the events sorted by time: switching the
+// speakers on a speaker pause before each event and playing it.
+func (a *Automation) timeline() []action {
+	actions := []action{}
+	for _, e := range a.events() {
+		actions = append(actions,
+			action{kind: SWITCH_ON_ACTION, at: e.Time.Add(-*a.speakerPause), event: e},
+			action{kind: PLAY_ACTION, at: e.Time, event: e})
+	}
+	sort.SliceStable(actions, func(i, j int) bool { return actions[i].at.Before(actions[j].at) })
+	return actions
+}
+
+// Scheduler runs the automation, waiting for its actions with timers.
+type Scheduler struct {
+	automation *Automation
+	location   *time.Location
+
+	// clock reads the wall clock and waits. It is the automation's clock.
+	clock clock.Clock
+}
+
+func NewScheduler(a *Automation, loc *time.Location) (*Scheduler, error) {
+	if a == nil {
+		return nil, errors.New("Scheduler expects a non-nil Automation.")
+	}
+	if loc == nil {
+		return nil, errors.New("Scheduler expects a non-nil timezone.")
+	}
+	return &Scheduler{automation: a, location: loc, clock: clock.OrSystem(a.clock)}, nil
+}
+
+// Run runs the automation until it fails permanently or ctx is cancelled,
+// returning ctx.Err() then. Transient errors are logged and retried after
+// TRANSIENT_RETRY.
+func (s *Scheduler) Run(ctx context.Context) error {
+	return s.RunUntil(ctx, time.Time{})
+}
+
+// RunUntil runs the automation like Run until the clock reaches end, if
+// non-zero e.g. to simulate a day with a fake clock.
+func (s *Scheduler) RunUntil(ctx context.Context, end time.Time) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		now := s.clock.Now()
+		if !end.IsZero() && !now.Before(end) {
+			return nil
+		}
+		wait, err := s.automation.RunAndSleep(ctx, now.In(s.location))
+		switch {
+		case ctx.Err() != nil:
+			return ctx.Err()
+		case httpclient.IsTransient(err):
+			log.Printf("Running the automation failed, retrying in %v: %v", TRANSIENT_RETRY, err)
+			wait = TRANSIENT_RETRY
+		case err != nil:
+			return fmt.Errorf("Running the automation failed: %w", err)
+		}
+		deadline := s.clock.Now().Add(wait)
+		if !end.IsZero() && deadline.After(end) {
+			deadline = end
+		}
+		if err := s.waitUntil(ctx, deadline); err != nil {
+			return err
+		}
+	}
+}
+
+// waitUntil waits until the wall clock reaches deadline with timers of at most
+// MAX_TIMER. It returns early if the wall clock jumped, so the timeline is
+// recomputed. It returns ctx.Err() if ctx is cancelled.
+func (s *Scheduler) waitUntil(ctx context.Context, deadline time.Time) error {
+	// Round(0) strips the monotonic clock reading to compare wall clocks.
+	deadline = deadline.Round(0)
+	if wait := deadline.Sub(s.clock.Now().Round(0)); wait > MAX_TIMER {
+		log.Printf("Sleeping for %v until %v", wait, deadline.In(s.location))
+	}
+
+	for {
+		before := s.clock.Now()
+		remaining := deadline.Sub(before.Round(0))
+		if remaining <= 0 {
+			return nil
+		}
+		if remaining > MAX_TIMER {
+			remaining = MAX_TIMER
+		}
+		timer := s.clock.NewTimer(remaining)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C():
+		}
+
+		// The monotonic clock elapsed for the timer, unlike the wall clock
+		// after a suspend or a clock change.
+		after := s.clock.Now()
+		if jump := after.Round(0).Sub(before.Round(0)) - after.Sub(before); jump >= CLOCK_JUMP || jump <= -CLOCK_JUMP {
+			log.Printf("The wall clock jumped by %v. Re-arming the timers.", jump)
+			return nil
+		}
+	}
+}
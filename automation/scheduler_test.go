@@ -0,0 +1,262 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package automation
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/ssafty/adhan-homeassistant-pi/clock"
+	"github.com/ssafty/adhan-homeassistant-pi/prayertimes"
+)
+
+func TestRunAndSleepTimeline(t *testing.T) {
+	at := func(hour, min, sec int) time.Time {
+		return time.Date(2024, time.March, 14, hour, min, sec, 0, time.UTC)
+	}
+
+	for _, test := range []struct {
+		description string
+		pause       time.Duration
+		// steps are the wall clock times of the RunAndSleep calls e.g.
+		// after a suspend or a clock change.
+		steps []time.Time
+
+		wantActions []int
+		wantSleeps  []time.Duration
+	}{
+		{
+			description: "Speakers are switched on a speaker pause before Fajr",
+			pause:       10 * time.Second,
+			steps:       []time.Time{at(8, 0, 0), at(8, 59, 50), at(9, 0, 0)},
+
+			wantActions: []int{aTurnSwitchOff, aTurnSwitchOn, aPlay},
+			wantSleeps:  []time.Duration{59*time.Minute + 50*time.Second, 10 * time.Second, PLAYBACK_POLL},
+		},
+		{
+			description: "Adhan plays once when the clock jumps back",
+			pause:       10 * time.Second,
+			steps:       []time.Time{at(8, 59, 50), at(9, 0, 0), at(9, 0, 5), at(8, 30, 0), at(9, 0, 0)},
+
+			wantActions: []int{aTurnSwitchOn, aPlay, aIsPlaying, aTurnSwitchOff},
+			// Sleeps till 11:59:50, a speaker pause before Dhuhr.
+			wantSleeps: []time.Duration{10 * time.Second, PLAYBACK_POLL, PLAYBACK_POLL, 3*time.Hour + 29*time.Minute + 50*time.Second, 2*time.Hour + 59*time.Minute + 50*time.Second},
+		},
+		{
+			description: "Adhan plays late after a short suspend",
+			steps:       []time.Time{at(8, 0, 0), at(9, 1, 0)},
+
+			wantActions: []int{aTurnSwitchOff, aTurnSwitchOn, aPlay},
+			wantSleeps:  []time.Duration{time.Hour, PLAYBACK_POLL},
+		},
+		{
+			description: "Adhan is skipped after a long suspend",
+			steps:       []time.Time{at(8, 0, 0), at(9, 30, 0)},
+
+			wantActions: []int{aTurnSwitchOff},
+			wantSleeps:  []time.Duration{time.Hour, 2*time.Hour + 30*time.Minute},
+		},
+	} {
+		t.Run(test.description, func(t *testing.T) {
+			actions := []int{}
+			pause := test.pause
+			a := Automation{
+				adhanPlayer:   &adhanPlayerMock{actionLogger: &actions},
+				homeassistant: &homeassistantMock{actionLogger: &actions},
+				prayerTimes:   &prayerTimesMock{},
+				speakerPause:  &pause,
+			}
+
+			sleeps := []time.Duration{}
+			for _, now := range test.steps {
+				sleep, err := a.RunAndSleep(context.Background(), now)
+				if err != nil {
+					t.Fatalf("RunAndSleep expects no error. Got %v", err)
+				}
+				sleeps = append(sleeps, sleep)
+			}
+			if !cmp.Equal(actions, test.wantActions) {
+				t.Errorf("RunAndSleep action sequence mismatch. Got %v, want %v", actions, test.wantActions)
+			}
+			if !cmp.Equal(sleeps, test.wantSleeps) {
+				t.Errorf("RunAndSleep sleep durations mismatch. Got %v, want %v", sleeps, test.wantSleeps)
+			}
+		})
+	}
+}
+
+// timerCountingClock counts the timers of a fake clock and checks them against
+// MAX_TIMER. jump is added to the wall clock before the first timer e.g. by a
+// suspend.
+type timerCountingClock struct {
+	*clock.Fake
+	jump   time.Duration
+	timers int
+	t      *testing.T
+}
+
+func (c *timerCountingClock) NewTimer(d time.Duration) clock.Timer {
+	if d > MAX_TIMER {
+		c.t.Errorf("Timer of %v exceeds MAX_TIMER", d)
+	}
+	if c.timers == 0 {
+		c.Set(c.Now().Add(c.jump))
+	}
+	c.timers++
+	return c.Fake.NewTimer(d)
+}
+
+func TestSchedulerWaitUntil(t *testing.T) {
+	start := time.Date(2024, time.March, 14, 8, 0, 0, 0, time.UTC)
+
+	for _, test := range []struct {
+		description string
+		deadline    time.Time
+		// jump is added to the wall clock during the first timer e.g. by a
+		// suspend.
+		jump time.Duration
+
+		wantTimers int
+		wantNow    time.Time
+	}{
+		{
+			description: "Waits with bounded timers",
+			deadline:    start.Add(5*time.Minute + 30*time.Second),
+			wantTimers:  6,
+			wantNow:     start.Add(5*time.Minute + 30*time.Second),
+		},
+		{
+			description: "Deadline has passed",
+			deadline:    start.Add(-time.Minute),
+			wantTimers:  0,
+			wantNow:     start,
+		},
+		{
+			description: "Wall clock jumped past the deadline",
+			deadline:    start.Add(time.Hour),
+			jump:        2 * time.Hour,
+			wantTimers:  1,
+			wantNow:     start.Add(2*time.Hour + MAX_TIMER),
+		},
+	} {
+		t.Run(test.description, func(t *testing.T) {
+			c := &timerCountingClock{Fake: clock.NewFake(start), jump: test.jump, t: t}
+			c.AutoAdvance = true
+			s := &Scheduler{location: time.UTC, clock: c}
+
+			if err := s.waitUntil(context.Background(), test.deadline); err != nil {
+				t.Fatalf("waitUntil expects no error. Got %v", err)
+			}
+			if c.timers != test.wantTimers {
+				t.Errorf("Timers mismatch. Got %v, want %v", c.timers, test.wantTimers)
+			}
+			if now := c.Now(); !now.Equal(test.wantNow) {
+				t.Errorf("Wall clock after waiting mismatch. Got %v, want %v", now, test.wantNow)
+			}
+		})
+	}
+}
+
+// cancellingClock cancels a context on the first timer.
+type cancellingClock struct {
+	*clock.Fake
+	cancel context.CancelFunc
+}
+
+func (c *cancellingClock) NewTimer(d time.Duration) clock.Timer {
+	c.cancel()
+	return c.Fake.NewTimer(d)
+}
+
+func TestSchedulerRunCancelled(t *testing.T) {
+	actions := []int{}
+	pause := time.Duration(0)
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Scheduler{
+		automation: &Automation{
+			adhanPlayer:   &adhanPlayerMock{actionLogger: &actions},
+			homeassistant: &homeassistantMock{actionLogger: &actions},
+			prayerTimes:   &prayerTimesMock{},
+			speakerPause:  &pause,
+		},
+		location: time.UTC,
+		// docker stop arrives during the first timer.
+		clock: &cancellingClock{clock.NewFake(time.Date(2024, time.March, 14, 8, 0, 0, 0, time.UTC)), cancel},
+	}
+
+	if err := s.Run(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("Run expected context.Canceled once cancelled. Got %v", err)
+	}
+	if want := []int{aTurnSwitchOff}; !cmp.Equal(actions, want) {
+		t.Errorf("Run action sequence mismatch. Got %v, want %v", actions, want)
+	}
+}
+
+func TestSchedulerSimulateYear(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatalf("Failed to load the timezone: %v", err)
+	}
+	// A leap year, calculated for Munich so every day is covered.
+	start := time.Date(2024, time.January, 1, 0, 0, 0, 0, berlin)
+	end := time.Date(2024, time.December, 31, 0, 0, 0, 0, berlin)
+	days := 365
+	fake := clock.NewFake(start)
+	fake.AutoAdvance = true
+
+	params := prayertimes.CalculationParams{Latitude: prayertimes.MUNICH_LATITUDE, Longitude: prayertimes.MUNICH_LONGITUDE, Timezone: berlin}
+	pt, err := prayertimes.NewCalculatedPrayerTimes(params, prayertimes.PrayerTimesClock(fake))
+	if err != nil {
+		t.Fatalf("NewCalculatedPrayerTimes returned error, expected None: %v", err)
+	}
+	actions := []int{}
+	pause := 10 * time.Second
+	a := &Automation{
+		adhanPlayer:   &adhanPlayerMock{actionLogger: &actions},
+		homeassistant: &homeassistantMock{actionLogger: &actions},
+		prayerTimes:   pt,
+		speakerPause:  &pause,
+		clock:         fake,
+	}
+	s, err := NewScheduler(a, berlin)
+	if err != nil {
+		t.Fatalf("NewScheduler returned error, expected None: %v", err)
+	}
+
+	if err := s.RunUntil(context.Background(), end); err != nil {
+		t.Fatalf("RunUntil returned error, expected None: %v", err)
+	}
+	counts := map[int]int{}
+	for _, action := range actions {
+		counts[action]++
+	}
+	// 5 Adhans a day, each switching the speakers on and off.
+	want := map[int]int{aPlay: days * 5, aIsPlaying: days * 5, aTurnSwitchOn: days * 5, aTurnSwitchOff: days*5 + 1}
+	if !cmp.Equal(counts, want) {
+		t.Errorf("Actions of a simulated year mismatch. Got %v, want %v", counts, want)
+	}
+}
+
+func TestNewScheduler(t *testing.T) {
+	if _, err := NewScheduler(nil, time.UTC); err == nil {
+		t.Errorf("NewScheduler expected an error without an automation. Got none.")
+	}
+	if _, err := NewScheduler(&Automation{}, nil); err == nil {
+		t.Errorf("NewScheduler expected an error without a timezone. Got none.")
+	}
+}
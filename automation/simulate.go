@@ -0,0 +1,271 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Simulation. Runs the automation over a date range with a fake clock, a
+// simulated switch and simulated players, records every switch action and play
+// and checks them for anomalies: missed events, double or unexpected plays,
+// plays with the speakers off and speakers left on for too long.
+
+package automation
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/ssafty/adhan-homeassistant-pi/clock"
+	"github.com/ssafty/adhan-homeassistant-pi/prayertimes"
+)
+
+// simulationRecordKind is a recorded action of the simulation.
+type simulationRecordKind string
+
+const (
+	SIMULATED_SWITCH_ON  simulationRecordKind = "switch on"
+	SIMULATED_SWITCH_OFF simulationRecordKind = "switch off"
+	SIMULATED_PLAY       simulationRecordKind = "play"
+)
+
+type simulationRecord struct {
+	at   time.Time
+	kind simulationRecordKind
+	// player is the name of the played player e.g. its mp3 file.
+	player string
+}
+
+func (r simulationRecord) String() string {
+	if r.kind == SIMULATED_PLAY {
+		return fmt.Sprintf("%v  %v %v", r.at.Format(SIMULATION_LAYOUT), r.kind, r.player)
+	}
+	return fmt.Sprintf("%v  %v", r.at.Format(SIMULATION_LAYOUT), r.kind)
+}
+
+const SIMULATION_LAYOUT = "2006-01-02 15:04:05 MST"
+
+// Simulation records the actions of the automation on a fake clock.
+type Simulation struct {
+	clock *clock.Fake
+	// playback is the length of the simulated audio.
+	playback time.Duration
+	records  []simulationRecord
+}
+
+// NewSimulation starts a simulation at start on a fake clock. The simulated
+// players play for playback.
+func NewSimulation(start time.Time, playback time.Duration) *Simulation {
+	c := clock.NewFake(start)
+	c.AutoAdvance = true
+	return &Simulation{clock: c, playback: playback}
+}
+
+// Clock returns the fake clock of the simulation e.g. for the prayer times and
+// AutomationClock.
+func (s *Simulation) Clock() *clock.Fake {
+	return s.clock
+}
+
+func (s *Simulation) record(kind simulationRecordKind, player string) {
+	s.records = append(s.records, simulationRecord{at: s.clock.Now(), kind: kind, player: player})
+}
+
+// Player returns a simulated player named e.g. by its mp3 file.
+func (s *Simulation) Player(name string) IAdhanPlayer {
+	return &simulatedPlayer{simulation: s, name: name}
+}
+
+// simulatedPlayer plays for the simulation's playback on its clock.
+type simulatedPlayer struct {
+	simulation *Simulation
+	name       string
+	until      time.Time
+}
+
+func (p *simulatedPlayer) Play(ctx context.Context) error {
+	p.simulation.record(SIMULATED_PLAY, p.name)
+	p.until = p.simulation.clock.Now().Add(p.simulation.playback)
+	return nil
+}
+
+func (p *simulatedPlayer) IsPlaying() bool {
+	return p.simulation.clock.Now().Before(p.until)
+}
+
+func (p *simulatedPlayer) Stop() {
+	p.until = time.Time{}
+}
+
+// Switch returns the simulated switch.
+func (s *Simulation) Switch() IHomeAssistant {
+	return &simulatedSwitch{simulation: s}
+}
+
+// simulatedSwitch records the switch actions.
+type simulatedSwitch struct {
+	simulation *Simulation
+}
+
+func (h *simulatedSwitch) TurnSwitchOn(ctx context.Context) (string, error) {
+	h.simulation.record(SIMULATED_SWITCH_ON, "")
+	return "simulated", nil
+}
+
+func (h *simulatedSwitch) TurnSwitchOff(ctx context.Context) (string, error) {
+	h.simulation.record(SIMULATED_SWITCH_OFF, "")
+	return "simulated", nil
+}
+
+// Run runs the automation from the simulation's start until end.
+func (s *Simulation) Run(ctx context.Context, a *Automation, loc *time.Location, end time.Time) error {
+	scheduler, err := NewScheduler(a, loc)
+	if err != nil {
+		return err
+	}
+	return scheduler.RunUntil(ctx, end)
+}
+
+// SimulationCheck are the expectations of the recorded actions.
+type SimulationCheck struct {
+	// Automation is the simulated automation. Its events are expected to play
+	// within its catch-up window.
+	Automation *Automation
+	From, To   time.Time
+	// MaxSpeakerOn is the longest time the speakers may stay on.
+	MaxSpeakerOn time.Duration
+}
+
+// events returns the events of the automation between from and to sorted by
+// time.
+func (s *Simulation) events(ctx context.Context, a *Automation, from, to time.Time) ([]event, error) {
+	events := []event{}
+	seen := map[string]bool{}
+	for day := prayertimes.GetDate(from); day.Before(to); day = prayertimes.AdjacentDay(day, 1) {
+		if err := a.prayerTimes.GetTodayPrayerTimes(ctx, day); err != nil {
+			return nil, fmt.Errorf("error reading the prayer times of %v: %w", day.Format(prayertimes.DATE_LAYOUT), err)
+		}
+		for _, e := range a.events() {
+			if e.Time.Before(from) || !e.Time.Before(to) || seen[e.key()] {
+				continue
+			}
+			seen[e.key()] = true
+			events = append(events, e)
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Time.Before(events[j].Time) })
+	return events, nil
+}
+
+// playerName returns the name of a simulated player, if any.
+func playerName(p IAdhanPlayer) string {
+	if sp, ok := p.(*simulatedPlayer); ok {
+		return sp.name
+	}
+	return ""
+}
+
+// Anomalies checks the recorded actions: every event of the automation between
+// From and To plays once on its player within the catch-up window, which
+// starts once the previous playback ended, nothing else plays, nothing plays
+// with the speakers off and the speakers are on for at most MaxSpeakerOn.
+func (s *Simulation) Anomalies(ctx context.Context, c SimulationCheck) ([]string, error) {
+	anomalies := []string{}
+
+	events, err := s.events(ctx, c.Automation, c.From, c.To)
+	if err != nil {
+		return nil, err
+	}
+	// Each play counts for the latest event of its player.
+	counts := map[string]int{}
+	var playedUntil time.Time
+	for _, r := range s.records {
+		if r.kind != SIMULATED_PLAY {
+			continue
+		}
+		var played *event
+		for i := range events {
+			if events[i].Time.After(r.at) {
+				break
+			}
+			if playerName(c.Automation.player(events[i].kind)) == r.player {
+				played = &events[i]
+			}
+		}
+		due := time.Time{}
+		if played != nil {
+			due = played.Time
+			if due.Before(playedUntil) {
+				due = playedUntil
+			}
+		}
+		if played != nil && r.at.Sub(due) <= c.Automation.CatchUp() {
+			counts[played.key()]++
+		} else {
+			anomalies = append(anomalies, fmt.Sprintf("%v: played %v at no event time", r.at.Format(SIMULATION_LAYOUT), r.player))
+		}
+		playedUntil = r.at.Add(s.playback)
+	}
+	for _, e := range events {
+		switch count := counts[e.key()]; {
+		case count == 0:
+			anomalies = append(anomalies, fmt.Sprintf("%v: missed the %v of %v", e.Time.Format(SIMULATION_LAYOUT), e.kind, e.Name))
+		case count > 1:
+			anomalies = append(anomalies, fmt.Sprintf("%v: played the %v of %v %d times", e.Time.Format(SIMULATION_LAYOUT), e.kind, e.Name, count))
+		}
+	}
+
+	var switchedOn time.Time
+	for _, r := range s.records {
+		switch r.kind {
+		case SIMULATED_SWITCH_ON:
+			if switchedOn.IsZero() {
+				switchedOn = r.at
+			}
+		case SIMULATED_SWITCH_OFF:
+			if !switchedOn.IsZero() && r.at.Sub(switchedOn) > c.MaxSpeakerOn {
+				anomalies = append(anomalies, fmt.Sprintf("%v: the speakers were on for %v", switchedOn.Format(SIMULATION_LAYOUT), r.at.Sub(switchedOn)))
+			}
+			switchedOn = time.Time{}
+		case SIMULATED_PLAY:
+			if switchedOn.IsZero() {
+				anomalies = append(anomalies, fmt.Sprintf("%v: played %v with the speakers off", r.at.Format(SIMULATION_LAYOUT), r.player))
+			}
+		}
+	}
+	if !switchedOn.IsZero() && c.To.Sub(switchedOn) > c.MaxSpeakerOn {
+		anomalies = append(anomalies, fmt.Sprintf("%v: the speakers were left on", switchedOn.Format(SIMULATION_LAYOUT)))
+	}
+
+	sort.Strings(anomalies)
+	return anomalies, nil
+}
+
+// Report writes the recorded actions, a summary and the anomalies.
+func (s *Simulation) Report(w io.Writer, from, to time.Time, anomalies []string) {
+	counts := map[simulationRecordKind]int{}
+	for _, r := range s.records {
+		fmt.Fprintln(w, r)
+		counts[r.kind]++
+	}
+	fmt.Fprintf(w, "\nSimulated %v to %v: %d plays, %d switch-ons, %d switch-offs.\n",
+		from.Format(prayertimes.DATE_LAYOUT), to.Format(prayertimes.DATE_LAYOUT), counts[SIMULATED_PLAY], counts[SIMULATED_SWITCH_ON], counts[SIMULATED_SWITCH_OFF])
+	if len(anomalies) == 0 {
+		fmt.Fprintln(w, "No anomalies found.")
+		return
+	}
+	fmt.Fprintf(w, "Anomalies (%d):\n", len(anomalies))
+	for _, anomaly := range anomalies {
+		fmt.Fprintf(w, "  %v\n", anomaly)
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package automation
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSimulation(t *testing.T) {
+	from := time.Date(2024, time.March, 14, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 0, 2)
+	sim := NewSimulation(from, 4*time.Minute)
+
+	pause := 10 * time.Second
+	pt := &prayerTimesMock{iqama: 10 * time.Minute}
+	a, err := NewAutomation(sim.Player("adhan.mp3"), sim.Switch(), pt,
+		SpeakerPause(&pause), AutomationClock(sim.Clock()), Iqama(sim.Player("iqama.mp3"), nil))
+	if err != nil {
+		t.Fatalf("NewAutomation returned error, expected None: %v", err)
+	}
+	if err := sim.Run(context.Background(), a, time.UTC, to); err != nil {
+		t.Fatalf("run returned error, expected None: %v", err)
+	}
+
+	counts := map[string]int{}
+	for _, r := range sim.records {
+		counts[string(r.kind)+" "+r.player]++
+	}
+	// 5 Adhans and 5 iqamas a day, switching the speakers off in between.
+	want := map[string]int{"play adhan.mp3": 10, "play iqama.mp3": 10, "switch on ": 20, "switch off ": 21}
+	if !cmp.Equal(counts, want) {
+		t.Errorf("Simulated actions mismatch. Got %v, want %v", counts, want)
+	}
+
+	anomalies, err := sim.Anomalies(context.Background(), SimulationCheck{
+		Automation:   a,
+		From:         from,
+		To:           to,
+		MaxSpeakerOn: 5 * time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("anomalies returned error, expected None: %v", err)
+	}
+	if len(anomalies) != 0 {
+		t.Errorf("Simulation expected no anomalies. Got %v", anomalies)
+	}
+
+	report := &bytes.Buffer{}
+	sim.Report(report, from, to, anomalies)
+	if !strings.Contains(report.String(), "2024-03-14 09:00:00 UTC  play adhan.mp3\n") || !strings.HasSuffix(report.String(), "No anomalies found.\n") {
+		t.Errorf("Report misses the Fajr Adhan or the summary:\n%v", report)
+	}
+}
+
+func TestSimulationAnomalies(t *testing.T) {
+	from := time.Date(2024, time.March, 14, 0, 0, 0, 0, time.UTC)
+	at := func(hour, min int) time.Time {
+		return time.Date(2024, time.March, 14, hour, min, 0, 0, time.UTC)
+	}
+	on := func(t time.Time) simulationRecord { return simulationRecord{at: t, kind: SIMULATED_SWITCH_ON} }
+	off := func(t time.Time) simulationRecord { return simulationRecord{at: t, kind: SIMULATED_SWITCH_OFF} }
+	play := func(t time.Time) simulationRecord {
+		return simulationRecord{at: t, kind: SIMULATED_PLAY, player: "adhan.mp3"}
+	}
+
+	sim := &Simulation{}
+	sim.records = []simulationRecord{
+		// Fajr plays twice.
+		on(at(8, 59)), play(at(9, 0)), play(at(9, 1)), off(at(9, 5)),
+		// Dhuhr is missed, but the Adhan plays at no prayer time.
+		on(at(12, 30)), play(at(12, 30)), off(at(12, 35)),
+		// Asr plays with the speakers off.
+		play(at(15, 0)),
+		// The speakers stay on after Maghrib and Ishaa.
+		on(at(17, 59)), play(at(18, 0)), play(at(21, 0)),
+	}
+	a := &Automation{adhanPlayer: sim.Player("adhan.mp3"), prayerTimes: &prayerTimesMock{}}
+	// The iqama of Maghrib never plays.
+	Iqama(sim.Player("iqama.mp3"), map[string]time.Duration{"Maghrib": 5 * time.Minute})(a)
+	anomalies, err := sim.Anomalies(context.Background(), SimulationCheck{
+		Automation:   a,
+		From:         from,
+		To:           from.AddDate(0, 0, 1),
+		MaxSpeakerOn: 10 * time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("anomalies returned error, expected None: %v", err)
+	}
+
+	want := []string{
+		"2024-03-14 09:00:00 UTC: played the adhan of Fajr 2 times",
+		"2024-03-14 12:00:00 UTC: missed the adhan of Dhuhr",
+		"2024-03-14 12:30:00 UTC: played adhan.mp3 at no event time",
+		"2024-03-14 15:00:00 UTC: played adhan.mp3 with the speakers off",
+		"2024-03-14 17:59:00 UTC: the speakers were left on",
+		"2024-03-14 18:05:00 UTC: missed the iqama of Maghrib",
+	}
+	if diff := cmp.Diff(want, anomalies); diff != "" {
+		t.Errorf("Anomalies mismatch (-want +got):\n%s", diff)
+	}
+}
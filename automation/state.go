@@ -0,0 +1,111 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Automation state. Persists the fired actions of the timeline and the time of
+// the last run to a small JSON file, so a restart neither replays an event nor
+// silently skips one:
+//
+//	{
+//	  "last_played": "adhan Fajr at 2024-03-14T05:01:00+01:00",
+//	  "fired": ["play adhan Fajr at 2024-03-14T05:01:00+01:00", ...],
+//	  "last_run": "2024-03-14T05:01:10+01:00",
+//	  "last_validated": "2024-03-14T04:12:00+01:00"
+//	}
+
+package automation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/ssafty/adhan-homeassistant-pi/internal/atomicfile"
+)
+
+type automationState struct {
+	// LastPlayed is the key of the last played event.
+	LastPlayed string `json:"last_played"`
+	// Fired are the keys of the fired actions of the timeline.
+	Fired []string `json:"fired"`
+	// LastRun is the timestamp of the last RunAndSleep.
+	LastRun time.Time `json:"last_run"`
+	// LastValidated is the timestamp of the last ValidateAllActions.
+	LastValidated time.Time `json:"last_validated"`
+}
+
+// readState reads the state file. A missing file is an empty state.
+func readState(fpath string) (automationState, error) {
+	state := automationState{}
+	body, err := os.ReadFile(fpath)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return state, err
+	}
+	if err := json.Unmarshal(body, &state); err != nil {
+		return automationState{}, fmt.Errorf("%s: error decoding JSON: %w", fpath, err)
+	}
+	return state, nil
+}
+
+// restoreState restores the fired actions and the last run from the state
+// file, if any. A malformed state file is logged and ignored.
+func (a *Automation) restoreState() {
+	a.fired = map[string]bool{}
+	if a.stateFpath == "" {
+		return
+	}
+
+	state, err := readState(a.stateFpath)
+	if err != nil {
+		log.Printf("Warning: ignoring the automation state: %v", err)
+		return
+	}
+	for _, key := range state.Fired {
+		a.fired[key] = true
+	}
+	a.lastPlayed, a.lastRun, a.lastValidated = state.LastPlayed, state.LastRun, state.LastValidated
+	if a.lastPlayed != "" {
+		log.Printf("Restored the automation state. Last played: %v", a.lastPlayed)
+	}
+}
+
+// saveState writes the fired actions and the last run to the state file, if
+// any. Failures are logged, playing the events is more important. The state
+// isn't saved before it is restored e.g. on a shutdown during the validation,
+// which would overwrite the state file with an empty one.
+func (a *Automation) saveState() {
+	if a.stateFpath == "" || a.fired == nil {
+		return
+	}
+
+	state := automationState{LastPlayed: a.lastPlayed, Fired: []string{}, LastRun: a.lastRun, LastValidated: a.lastValidated}
+	for key := range a.fired {
+		state.Fired = append(state.Fired, key)
+	}
+	sort.Strings(state.Fired)
+
+	body, err := json.MarshalIndent(state, "", "  ")
+	if err == nil {
+		err = atomicfile.Write(a.stateFpath, body)
+	}
+	if err != nil {
+		log.Printf("Warning: failed to save the automation state: %v", err)
+	}
+}
@@ -0,0 +1,233 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package automation
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/ssafty/adhan-homeassistant-pi/clock"
+)
+
+func TestRunAndSleepRestart(t *testing.T) {
+	at := func(hour, min int) time.Time {
+		return time.Date(2024, time.March, 14, hour, min, 0, 0, time.UTC)
+	}
+
+	for _, test := range []struct {
+		description string
+		noState     bool
+		catchUp     time.Duration
+		// before runs before the restart and after runs after it.
+		before time.Time
+		after  time.Time
+
+		wantActions []int
+	}{
+		{
+			description: "Restart within the catch-up window doesn't replay",
+			before:      at(9, 0),
+			after:       at(9, 1),
+			wantActions: []int{},
+		},
+		{
+			description: "Restart without a state file replays",
+			noState:     true,
+			before:      at(9, 0),
+			after:       at(9, 1),
+			wantActions: []int{aPlay},
+		},
+		{
+			description: "Event missed during a restart plays within the catch-up window",
+			catchUp:     30 * time.Minute,
+			before:      at(8, 0),
+			after:       at(9, 20),
+			wantActions: []int{aPlay},
+		},
+		{
+			description: "Event missed during a restart is skipped after the catch-up window",
+			before:      at(8, 0),
+			after:       at(9, 20),
+			wantActions: []int{},
+		},
+	} {
+		t.Run(test.description, func(t *testing.T) {
+			stateFpath := filepath.Join(t.TempDir(), "state.json")
+			newAutomation := func(actions *[]int) *Automation {
+				a := &Automation{
+					adhanPlayer:   &adhanPlayerMock{actionLogger: actions},
+					homeassistant: &homeassistantMock{actionLogger: &[]int{}},
+					prayerTimes:   &prayerTimesMock{},
+					speakerPause:  func() *time.Duration { d := time.Duration(0 * time.Second); return &d }(),
+				}
+				CatchUpWindow(test.catchUp)(a)
+				if !test.noState {
+					StateFile(stateFpath)(a)
+				}
+				return a
+			}
+
+			if _, err := newAutomation(&[]int{}).RunAndSleep(context.Background(), test.before); err != nil {
+				t.Fatalf("RunAndSleep expects no error. Got %v", err)
+			}
+
+			actions := []int{}
+			if _, err := newAutomation(&actions).RunAndSleep(context.Background(), test.after); err != nil {
+				t.Fatalf("RunAndSleep expects no error. Got %v", err)
+			}
+			if !cmp.Equal(actions, test.wantActions) {
+				t.Errorf("RunAndSleep action sequence after the restart mismatch. Got %v, want %v", actions, test.wantActions)
+			}
+		})
+	}
+}
+
+func TestReadState(t *testing.T) {
+	dir := t.TempDir()
+
+	state, err := readState(filepath.Join(dir, "missing.json"))
+	if err != nil {
+		t.Errorf("readState of a missing file returned error, expected None: %v", err)
+	}
+	if !cmp.Equal(state, automationState{}) {
+		t.Errorf("readState of a missing file should be empty. Got %+v", state)
+	}
+
+	malformed := filepath.Join(dir, "malformed.json")
+	if err := os.WriteFile(malformed, []byte(`{"fired": [`), 0644); err != nil {
+		t.Fatalf("Failed to write the state: %v", err)
+	}
+	if _, err := readState(malformed); err == nil {
+		t.Errorf("readState of a malformed file should return an error. Got none.")
+	}
+
+	// A malformed state is ignored.
+	a := &Automation{stateFpath: malformed}
+	a.restoreState()
+	if len(a.fired) != 0 || !a.lastRun.IsZero() {
+		t.Errorf("restoreState of a malformed file should be empty. Got fired %v, last run %v", a.fired, a.lastRun)
+	}
+}
+
+func TestSaveState(t *testing.T) {
+	stateFpath := filepath.Join(t.TempDir(), "state.json")
+	lastRun := time.Date(2024, time.March, 14, 9, 0, 10, 0, time.UTC)
+	a := &Automation{
+		stateFpath: stateFpath,
+		fired:      map[string]bool{"play b": true, "play a": true},
+		lastPlayed: "a",
+		lastRun:    lastRun,
+	}
+	a.saveState()
+
+	got, err := readState(stateFpath)
+	if err != nil {
+		t.Fatalf("readState returned error, expected None: %v", err)
+	}
+	want := automationState{LastPlayed: "a", Fired: []string{"play a", "play b"}, LastRun: lastRun}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("State mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestShutdownBeforeRun(t *testing.T) {
+	stateFpath := filepath.Join(t.TempDir(), "state.json")
+	body := []byte(`{"last_played": "a", "fired": ["play a"]}`)
+	if err := os.WriteFile(stateFpath, body, 0644); err != nil {
+		t.Fatalf("Failed to write the state: %v", err)
+	}
+
+	a := &Automation{
+		adhanPlayer:   &adhanPlayerMock{actionLogger: &[]int{}},
+		homeassistant: &homeassistantMock{actionLogger: &[]int{}},
+		stateFpath:    stateFpath,
+	}
+	if err := a.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown expects no error. Got %v", err)
+	}
+
+	got, err := os.ReadFile(stateFpath)
+	if err != nil {
+		t.Fatalf("Failed to read the state: %v", err)
+	}
+	if string(got) != string(body) {
+		t.Errorf("Shutdown before Run should leave the state file unchanged. Got %s, want %s", got, body)
+	}
+}
+
+func TestValidateAllActionsRestart(t *testing.T) {
+	now := time.Date(2024, time.March, 14, 9, 0, 0, 0, time.UTC)
+
+	for _, test := range []struct {
+		description string
+		noState     bool
+		// validated is when the validation ran before the restart.
+		validated time.Time
+
+		wantActions []int
+	}{
+		{
+			description: "Restart on the same day doesn't replay",
+			validated:   now.Add(-time.Hour),
+			wantActions: []int{},
+		},
+		{
+			description: "Restart on the next day replays",
+			validated:   now.Add(-24 * time.Hour),
+			wantActions: []int{aTurnSwitchOn, aPlay, aTurnSwitchOff},
+		},
+		{
+			description: "Restart without a state file replays",
+			noState:     true,
+			validated:   now.Add(-time.Hour),
+			wantActions: []int{aTurnSwitchOn, aPlay, aTurnSwitchOff},
+		},
+	} {
+		t.Run(test.description, func(t *testing.T) {
+			stateFpath := filepath.Join(t.TempDir(), "state.json")
+			newAutomation := func(actions *[]int, at time.Time) *Automation {
+				fake := clock.NewFake(at)
+				fake.AutoAdvance = true
+				a := &Automation{
+					adhanPlayer:   &adhanPlayerMock{actionLogger: actions},
+					homeassistant: &homeassistantMock{actionLogger: actions},
+					prayerTimes:   &prayerTimesMock{},
+					speakerPause:  func() *time.Duration { d := time.Duration(0 * time.Second); return &d }(),
+					clock:         fake,
+				}
+				if !test.noState {
+					StateFile(stateFpath)(a)
+				}
+				return a
+			}
+
+			if err := newAutomation(&[]int{}, test.validated).ValidateAllActions(context.Background()); err != nil {
+				t.Fatalf("ValidateAllActions expects no error. Got %v", err)
+			}
+
+			actions := []int{}
+			if err := newAutomation(&actions, now).ValidateAllActions(context.Background()); err != nil {
+				t.Fatalf("ValidateAllActions expects no error. Got %v", err)
+			}
+			if !cmp.Equal(actions, test.wantActions) {
+				t.Errorf("ValidateAllActions action sequence after the restart mismatch. Got %v, want %v", actions, test.wantActions)
+			}
+		})
+	}
+}
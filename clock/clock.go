@@ -0,0 +1,200 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package clock reads the time and waits. The automation, the scheduler, the
+// prayer times and the players use a Clock, so tests and simulations can
+// replace the system clock with a fake clock and run days of scheduling in
+// milliseconds.
+
+package clock
+
+import (
+	"context"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock reads the wall clock and waits.
+type Clock interface {
+	Now() time.Time
+	// After waits for d and then sends the time on the channel.
+	After(d time.Duration) <-chan time.Time
+	Sleep(d time.Duration)
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer is a time.Timer of a Clock.
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+}
+
+// System is the real clock of the time package.
+var System Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (systemClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+func (systemClock) NewTimer(d time.Duration) Timer {
+	return systemTimer{time.NewTimer(d)}
+}
+
+type systemTimer struct {
+	timer *time.Timer
+}
+
+func (t systemTimer) C() <-chan time.Time {
+	return t.timer.C
+}
+
+func (t systemTimer) Stop() bool {
+	return t.timer.Stop()
+}
+
+// OrSystem returns c or the system clock if c is nil.
+func OrSystem(c Clock) Clock {
+	if c == nil {
+		return System
+	}
+	return c
+}
+
+// Sleep waits for t on the clock, nil is the system clock, or until ctx is
+// cancelled.
+func Sleep(ctx context.Context, c Clock, t time.Duration) error {
+	c = OrSystem(c)
+	log.Printf("Sleeping for %v until %v", t, c.Now().Add(t))
+	timer := c.NewTimer(t)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C():
+		return nil
+	}
+}
+
+// Fake is a Clock that only moves when advanced. Its timers fire once the
+// clock reaches them. Its times have no monotonic clock reading.
+type Fake struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+
+	// AutoAdvance advances the clock to a new timer right away, so a single
+	// goroutine e.g. a simulation never blocks on a timer.
+	AutoAdvance bool
+}
+
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now.Round(0)}
+}
+
+func (c *Fake) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *Fake) After(d time.Duration) <-chan time.Time {
+	return c.NewTimer(d).C()
+}
+
+// Sleep advances the clock by d.
+func (c *Fake) Sleep(d time.Duration) {
+	c.Advance(d)
+}
+
+func (c *Fake) NewTimer(d time.Duration) Timer {
+	c.mu.Lock()
+	t := &fakeTimer{clock: c, at: c.now.Add(d), c: make(chan time.Time, 1)}
+	c.timers = append(c.timers, t)
+	c.mu.Unlock()
+
+	if c.AutoAdvance {
+		c.AdvanceTo(t.at)
+	} else {
+		c.AdvanceTo(c.Now())
+	}
+	return t
+}
+
+// Advance moves the clock forward by d, firing the timers due.
+func (c *Fake) Advance(d time.Duration) {
+	c.AdvanceTo(c.Now().Add(d))
+}
+
+// AdvanceTo moves the clock forward to t, firing the timers due in order. The
+// clock never moves backwards.
+func (c *Fake) AdvanceTo(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	sort.SliceStable(c.timers, func(i, j int) bool { return c.timers[i].at.Before(c.timers[j].at) })
+	for len(c.timers) > 0 && !c.timers[0].at.After(t) {
+		timer := c.timers[0]
+		c.timers = c.timers[1:]
+		if timer.at.After(c.now) {
+			c.now = timer.at
+		}
+		timer.c <- c.now
+	}
+	if t.After(c.now) {
+		c.now = t
+	}
+}
+
+// Set sets the wall clock to t without firing timers e.g. to simulate a clock
+// change.
+func (c *Fake) Set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = t.Round(0)
+}
+
+type fakeTimer struct {
+	clock *Fake
+	at    time.Time
+	c     chan time.Time
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+// Stop removes the timer, returning False if it fired already.
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	for i, timer := range t.clock.timers {
+		if timer == t {
+			t.clock.timers = append(t.clock.timers[:i], t.clock.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,71 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clock
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestFakeClock(t *testing.T) {
+	start := time.Date(2024, time.March, 14, 8, 0, 0, 0, time.UTC)
+	clock := NewFake(start)
+
+	late := clock.NewTimer(2 * time.Minute)
+	early := clock.NewTimer(time.Minute)
+	stopped := clock.NewTimer(time.Minute)
+	if !stopped.Stop() {
+		t.Errorf("Stop of a pending timer should return True.")
+	}
+
+	clock.Advance(90 * time.Second)
+	select {
+	case fired := <-early.C():
+		if want := start.Add(time.Minute); !fired.Equal(want) {
+			t.Errorf("Timer fired at %v, want %v", fired, want)
+		}
+	default:
+		t.Errorf("Timer due after a minute didn't fire.")
+	}
+	select {
+	case <-late.C():
+		t.Errorf("Timer due after 2 minutes fired early.")
+	case <-stopped.C():
+		t.Errorf("Stopped timer fired.")
+	default:
+	}
+	if now := clock.Now(); !now.Equal(start.Add(90 * time.Second)) {
+		t.Errorf("Now mismatch. Got %v, want %v", now, start.Add(90*time.Second))
+	}
+
+	clock.Sleep(time.Minute)
+	if late.Stop() {
+		t.Errorf("Stop of a fired timer should return False.")
+	}
+}
+
+func TestFakeClockAutoAdvance(t *testing.T) {
+	start := time.Date(2024, time.March, 14, 8, 0, 0, 0, time.UTC)
+	clock := NewFake(start)
+	clock.AutoAdvance = true
+
+	if err := Sleep(context.Background(), clock, time.Hour); err != nil {
+		t.Fatalf("Sleep expects no error. Got %v", err)
+	}
+	if now := clock.Now(); !now.Equal(start.Add(time.Hour)) {
+		t.Errorf("Now after sleeping mismatch. Got %v, want %v", now, start.Add(time.Hour))
+	}
+}
@@ -32,4 +32,5 @@ fi
 exec /adhan-homeassistant-pi \
     --switch_id $switch_id \
     --homeassistant_ip $homeassistant_ip \
-    --homeassistant_token $homeassistant_token
\ No newline at end of file
+    --homeassistant_token $homeassistant_token \
+    "$@"
\ No newline at end of file
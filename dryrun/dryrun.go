@@ -0,0 +1,73 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package dryrun has logging no-op implementations of IAdhanPlayer and
+// IHomeAssistant used by --dry_run to try a configuration e.g. on a laptop,
+// without speakers, home assistant or an audio device.
+
+package dryrun
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+)
+
+// LoggingPlayer logs the playback of an mp3 file instead of playing it. The
+// playback ends right away.
+type LoggingPlayer struct {
+	filePath string
+}
+
+// NewLoggingPlayer checks that the mp3 file exists, but doesn't decode it nor
+// create an audio context.
+func NewLoggingPlayer(fpath string) (*LoggingPlayer, error) {
+	if _, err := os.Stat(fpath); err != nil {
+		return nil, fmt.Errorf("NewLoggingPlayer failed: %w", err)
+	}
+	return &LoggingPlayer{filePath: fpath}, nil
+}
+
+func (p *LoggingPlayer) Play(ctx context.Context) error {
+	log.Printf("Dry run: playing %v.", p.filePath)
+	return nil
+}
+
+func (p *LoggingPlayer) IsPlaying() bool {
+	return false
+}
+
+func (p *LoggingPlayer) Stop() {}
+
+// LoggingSwitch logs the switch actions instead of sending them to home
+// assistant.
+type LoggingSwitch struct {
+	switchID string
+}
+
+// NewLoggingSwitch logs the actions of the switch with switchID.
+func NewLoggingSwitch(switchID string) *LoggingSwitch {
+	return &LoggingSwitch{switchID: switchID}
+}
+
+func (h *LoggingSwitch) TurnSwitchOn(ctx context.Context) (string, error) {
+	log.Printf("Dry run: switching on %v.", h.switchID)
+	return "dry run", nil
+}
+
+func (h *LoggingSwitch) TurnSwitchOff(ctx context.Context) (string, error) {
+	log.Printf("Dry run: switching off %v.", h.switchID)
+	return "dry run", nil
+}
@@ -0,0 +1,55 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dryrun
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ssafty/adhan-homeassistant-pi/automation"
+	"github.com/ssafty/adhan-homeassistant-pi/clock"
+	"github.com/ssafty/adhan-homeassistant-pi/prayertimes"
+)
+
+func TestNewAutomationDryRun(t *testing.T) {
+	fpath := filepath.Join(t.TempDir(), "adhan.mp3")
+	if err := os.WriteFile(fpath, []byte{}, 0644); err != nil {
+		t.Fatalf("Failed to write the mp3: %v", err)
+	}
+	if _, err := NewLoggingPlayer(filepath.Join(t.TempDir(), "missing.mp3")); err == nil {
+		t.Errorf("NewLoggingPlayer expected an error for a missing file. Got none.")
+	}
+	player, err := NewLoggingPlayer(fpath)
+	if err != nil {
+		t.Fatalf("NewLoggingPlayer returned error, expected None: %v", err)
+	}
+
+	now := time.Date(2023, time.March, 14, 8, 0, 0, 0, time.UTC)
+	pt, err := prayertimes.NewMunichPrayerTimes(prayertimes.PrayerTimesClock(clock.NewFake(now)))
+	if err != nil {
+		t.Fatalf("NewMunichPrayerTimes returned error, expected None: %v", err)
+	}
+	pause := time.Second
+	a, err := automation.NewAutomation(player, NewLoggingSwitch("switch.speakers"), pt, automation.SpeakerPause(&pause), automation.StateFile(""))
+	if err != nil {
+		t.Fatalf("NewAutomation with logging implementations returned error, expected None: %v", err)
+	}
+	if sleep, err := a.RunAndSleep(context.Background(), now); err != nil || sleep <= 0 {
+		t.Errorf("RunAndSleep with logging implementations returned %v, %v. Want a positive sleep, no error", sleep, err)
+	}
+}
@@ -12,16 +12,20 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
-// Home assistant API handler. Talks to Home assistant with REST commands to
-// control specific entities. An entity example is a Zigbee switch or a lightbulb.
+// Package homeassistant is the Home assistant API handler. Talks to Home
+// assistant with REST commands to control specific entities. An entity example
+// is a Zigbee switch or a lightbulb.
 
-package main
+package homeassistant
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
 	"strings"
+
+	"github.com/ssafty/adhan-homeassistant-pi/httpclient"
 )
 
 type SwitchAction string
@@ -32,51 +36,48 @@ const (
 	STATUS  SwitchAction = "/api/states/"
 )
 
-type IHomeAssistant interface {
-	TurnSwitchOn() (string, error)
-	TurnSwitchOff() (string, error)
-}
-
-type homeassistant struct {
-	client *httpclient
+// HomeAssistant turns a Home Assistant switch of the speakers on and off.
+type HomeAssistant struct {
+	client *httpclient.HTTPClient
 
 	switchID string
 	ipAddr   string
 }
 
-type homeassistantOpt func(*homeassistant)
+type HomeAssistantOpt func(*HomeAssistant)
 
-func IPAddress(ip string) homeassistantOpt {
+func IPAddress(ip string) HomeAssistantOpt {
 	url := strings.TrimRight(ip, "/")
 
-	return func(h *homeassistant) {
+	return func(h *HomeAssistant) {
 		h.ipAddr = url
 	}
 }
 
-func SwitchID(se string) homeassistantOpt {
-	return func(h *homeassistant) {
+func SwitchID(se string) HomeAssistantOpt {
+	return func(h *HomeAssistant) {
 		h.switchID = se
 	}
 }
 
-func HTTPClient(c *httpclient) homeassistantOpt {
-	return func(h *homeassistant) {
+func HTTPClient(c *httpclient.HTTPClient) HomeAssistantOpt {
+	return func(h *HomeAssistant) {
 		h.client = c
 	}
 }
 
 // Initializes HomeAssistant instance with a specific switch. NewHomeAssistant sends
 // on creation a GET request to homeassistant to verify that the token/ip are correct.
-func NewHomeAssistant(opts ...homeassistantOpt) (*homeassistant, error) {
-	ha := &homeassistant{}
+// Transient errors are retried by the client.
+func NewHomeAssistant(opts ...HomeAssistantOpt) (*HomeAssistant, error) {
+	ha := &HomeAssistant{}
 
 	for _, opt := range opts {
 		opt(ha)
 	}
 
 	switch {
-	case ha.client == nil || ha.client.token == "":
+	case ha.client == nil || ha.client.Token() == "":
 		return nil, errors.New("Httpclient with GET/POST features is not specified.")
 	case ha.switchID == "":
 		return nil, errors.New("NewHomeAssistant's switch id/entity is not specified.")
@@ -84,7 +85,7 @@ func NewHomeAssistant(opts ...homeassistantOpt) (*homeassistant, error) {
 		return nil, errors.New("NewHomeAssistant's IP address is not specified.")
 	}
 
-	if _, err := ha.getSwitchStatus(); err != nil {
+	if _, err := ha.getSwitchStatus(context.Background()); err != nil {
 		// This validation check may fail if the AuthToken, IP address or switchID are incorrect.
 		return nil, fmt.Errorf("NewHomeAssistant status validation check failed: %w", err)
 	}
@@ -94,18 +95,18 @@ func NewHomeAssistant(opts ...homeassistantOpt) (*homeassistant, error) {
 
 // makeSwitchAction is a private function that builds and sends the POST request
 // to home assistant to turn the switch on or off.
-func (h *homeassistant) makeSwitchAction(action SwitchAction) (string, error) {
+func (h *HomeAssistant) makeSwitchAction(ctx context.Context, action SwitchAction) (string, error) {
 	url := h.ipAddr + string(action)
 	payload := map[string]string{
 		"entity_id": h.switchID,
 	}
 
-	body, statusCode, err := h.client.Post(url, payload)
+	body, statusCode, err := h.client.Post(ctx, url, payload)
 	if err != nil {
 		return "", fmt.Errorf("encountered error from POST(%s, %v) request: %w", url, payload, err)
 	}
 	if statusCode != 200 {
-		return "", fmt.Errorf("unsuccessful response status code. Received statusCode: %d for POST(%s, %v): %v", statusCode, url, payload, body)
+		return "", statusError(statusCode, fmt.Errorf("unsuccessful response status code. Received statusCode: %d for POST(%s, %v): %v", statusCode, url, payload, body))
 	}
 
 	log.Printf("Speaker Action succeeded: %v", action)
@@ -114,24 +115,34 @@ func (h *homeassistant) makeSwitchAction(action SwitchAction) (string, error) {
 
 // getStatus query the status of the home automation entity that homeassistant
 // struct is initialized with i.e. h.switchID.
-func (h *homeassistant) getSwitchStatus() (string, error) {
+func (h *HomeAssistant) getSwitchStatus(ctx context.Context) (string, error) {
 	url := h.ipAddr + string(STATUS) + h.switchID
 
-	body, statusCode, err := h.client.Get(url)
+	body, statusCode, err := h.client.Get(ctx, url)
 	if err != nil {
 		return "", fmt.Errorf("encountered error from Get(%s) request: %w", url, err)
 	}
 	if statusCode != 200 {
-		return "", fmt.Errorf("unsuccessful response status code. Received statusCode: %d for Get(%s): %v", statusCode, url, body)
+		return "", statusError(statusCode, fmt.Errorf("unsuccessful response status code. Received statusCode: %d for Get(%s): %v", statusCode, url, body))
 	}
 
 	log.Printf("Speaker Action succeeded: %v", url)
 	return body, nil
 }
 
+// statusError marks the error of an unsuccessful status code as transient if
+// homeassistant may succeed on retry e.g. a 502 from a proxy while it restarts.
+// Other status codes e.g. a 401 for a wrong token are permanent.
+func statusError(statusCode int, err error) error {
+	if httpclient.IsTransientStatus(statusCode) {
+		return httpclient.Transient(err)
+	}
+	return err
+}
+
 // TurnSwitchOn turns the switch on.
-func (h *homeassistant) TurnSwitchOn() (string, error) {
-	resp, err := h.makeSwitchAction(TURNON)
+func (h *HomeAssistant) TurnSwitchOn(ctx context.Context) (string, error) {
+	resp, err := h.makeSwitchAction(ctx, TURNON)
 	if err != nil {
 		return "", fmt.Errorf("error switching on %v: %w", h.switchID, err)
 	}
@@ -139,8 +150,8 @@ func (h *homeassistant) TurnSwitchOn() (string, error) {
 }
 
 // TurnSwitchOff turns the switch off.
-func (h *homeassistant) TurnSwitchOff() (string, error) {
-	resp, err := h.makeSwitchAction(TURNOFF)
+func (h *HomeAssistant) TurnSwitchOff(ctx context.Context) (string, error) {
+	resp, err := h.makeSwitchAction(ctx, TURNOFF)
 	if err != nil {
 		return "", fmt.Errorf("error switching off %v: %w", h.switchID, err)
 	}
@@ -12,14 +12,17 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
-package main
+package homeassistant
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"io/ioutil"
 	"net/http"
 	"testing"
+
+	"github.com/ssafty/adhan-homeassistant-pi/httpclient"
 )
 
 const (
@@ -58,23 +61,22 @@ func (c *homeassistantHttpClientMock) Do(req *http.Request) (*http.Response, err
 
 func TestValidNewHomeAssistant(t *testing.T) {
 	h, err := NewHomeAssistant(
-		HTTPClient(&httpclient{
-			client: &homeassistantHttpClientMock{
+		HTTPClient(httpclient.NewHTTPClient(validAuthToken,
+			httpclient.Client(&homeassistantHttpClientMock{
 				ip:        validIp,
 				authToken: validAuthToken,
 				switchId:  validSwitchId,
-			},
-			token: validAuthToken,
-		}),
+			}),
+			httpclient.Retries(httpclient.Backoff{}))),
 		IPAddress(validIp),
 		SwitchID(validSwitchId))
 	if err != nil {
 		t.Fatalf("NewHomeAssistant with valid arguments should raise no errors. Got %v", err)
 	}
-	if _, err := h.TurnSwitchOff(); err != nil {
+	if _, err := h.TurnSwitchOff(context.Background()); err != nil {
 		t.Fatalf("NewHomeAssistant turn switch off action expect no errors. Got %v", err)
 	}
-	if _, err := h.TurnSwitchOn(); err != nil {
+	if _, err := h.TurnSwitchOn(context.Background()); err != nil {
 		t.Fatalf("NewHomeAssistant turn switch on action expect no errors. Got %v", err)
 	}
 }
@@ -119,14 +121,13 @@ func TestInvalidNewHomeAssistant(t *testing.T) {
 	} {
 		t.Run(test.description, func(t *testing.T) {
 			_, err := NewHomeAssistant(
-				HTTPClient(&httpclient{
-					client: &homeassistantHttpClientMock{
+				HTTPClient(httpclient.NewHTTPClient(test.authToken,
+					httpclient.Client(&homeassistantHttpClientMock{
 						ip:        test.ip,
 						authToken: test.authToken,
 						switchId:  test.switchId,
-					},
-					token: test.authToken,
-				}),
+					}),
+					httpclient.Retries(httpclient.Backoff{}))),
 				IPAddress(test.ip),
 				SwitchID(test.switchId))
 
@@ -0,0 +1,290 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package httpclient sends homeassistant's REST API GET and POST requests. It
+// encapsulates GET and POST requests logic away from homeassistant API handler
+// and also fetches the online prayer times.
+//
+// Errors are transient or permanent. Transient errors e.g. a refused connection
+// or a 503 while Home Assistant restarts for an update are retried with an
+// exponential backoff and jitter. Permanent errors e.g. a 401 for a wrong token
+// are returned right away.
+
+package httpclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ssafty/adhan-homeassistant-pi/clock"
+)
+
+// HTTP_TIMEOUT bounds a Home Assistant request, so an unresponsive instance is
+// a transient error rather than a hang.
+const HTTP_TIMEOUT = 10 * time.Second
+
+// DEFAULT_BACKOFF waits about 15 seconds between the retries of a request,
+// which outlasts a short restart of Home Assistant. With the HTTP_TIMEOUT of
+// each attempt, a request may take about 65 seconds. That outlasts a speaker
+// pause, so a failed switch-on delays its event, but stays within the catch-up
+// window of the automation.
+var DEFAULT_BACKOFF = Backoff{Attempts: 5, Initial: time.Second, Max: 8 * time.Second}
+
+// Backoff retries transient errors with exponentially growing delays.
+type Backoff struct {
+	// Attempts is the number of tries including the first. Zero or one
+	// disables retries.
+	Attempts int
+	// Initial is the delay before the first retry. It doubles per retry up to
+	// Max.
+	Initial time.Duration
+	Max     time.Duration
+}
+
+// delay returns the delay before the retry-th retry (from 0) with a jitter of
+// up to half of it, so clients don't retry in lockstep.
+func (b Backoff) delay(retry int) time.Duration {
+	d := b.Initial
+	for i := 0; i < retry && d < b.Max; i++ {
+		d *= 2
+	}
+	if d > b.Max {
+		d = b.Max
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// transientError is an error that may succeed on retry.
+type transientError struct {
+	err error
+}
+
+func (e *transientError) Error() string {
+	return e.err.Error()
+}
+
+func (e *transientError) Unwrap() error {
+	return e.err
+}
+
+// Transient marks err as transient.
+func Transient(err error) error {
+	return &transientError{err}
+}
+
+// IsTransient returns True if err or any error it wraps is transient.
+func IsTransient(err error) bool {
+	var t *transientError
+	return errors.As(err, &t)
+}
+
+// IsTransientStatus returns True for the status codes that may succeed on
+// retry: timeouts, rate limits and server errors.
+func IsTransientStatus(statusCode int) bool {
+	return statusCode == http.StatusRequestTimeout || statusCode == http.StatusTooManyRequests || statusCode >= 500
+}
+
+// isNetworkError returns True if err failed on the network e.g. a refused
+// connection, a DNS failure, a timeout or a dropped connection, which may
+// succeed on retry. A cancelled ctx isn't a network error.
+func isNetworkError(err error) bool {
+	// *url.Error wraps every error of http.Client and is itself a net.Error.
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		err = urlErr.Err
+	}
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// IClient sends the requests e.g. an *http.Client. It is used for mocking Do()
+// in unit tests.
+type IClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+type HTTPClient struct {
+	client IClient
+	token  string
+
+	// backoff retries the transient errors. The zero value doesn't retry.
+	backoff Backoff
+	// clock waits between retries. It defaults to the system clock.
+	clock clock.Clock
+}
+
+type HTTPClientOpt func(*HTTPClient)
+
+// Client replaces the *http.Client sending the requests e.g. by a mock.
+func Client(c IClient) HTTPClientOpt {
+	return func(h *HTTPClient) {
+		h.client = c
+	}
+}
+
+// Retries replaces DEFAULT_BACKOFF. The zero value doesn't retry.
+func Retries(b Backoff) HTTPClientOpt {
+	return func(h *HTTPClient) {
+		h.backoff = b
+	}
+}
+
+// Clock replaces the system clock waiting between retries.
+func Clock(c clock.Clock) HTTPClientOpt {
+	return func(h *HTTPClient) {
+		h.clock = c
+	}
+}
+
+// NewHTTPClient sends requests with a bearer token, if any, retrying the
+// transient errors by DEFAULT_BACKOFF.
+func NewHTTPClient(token string, opts ...HTTPClientOpt) *HTTPClient {
+	h := &HTTPClient{
+		client:  &http.Client{Timeout: HTTP_TIMEOUT},
+		token:   token,
+		backoff: DEFAULT_BACKOFF,
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+// Token returns the bearer token of the requests.
+func (c *HTTPClient) Token() string {
+	return c.token
+}
+
+// retry sends the requests of newReq until one succeeds with a non-transient
+// status code, the attempts of the backoff are used or ctx is cancelled. A
+// transient status code of the last attempt is returned as is. Requests that
+// failed on the network are transient errors, other failures e.g. of a
+// malformed URL are returned right away.
+func (c *HTTPClient) retry(ctx context.Context, newReq func() (*http.Request, error)) (string, int, error) {
+	for attempt := 1; ; attempt++ {
+		req, err := newReq()
+		if err != nil {
+			return "", 0, err
+		}
+		resp, statusCode, err := c.sendReq(req, c.token)
+		retryable := (IsTransient(err) && ctx.Err() == nil) || (err == nil && IsTransientStatus(statusCode))
+		if !retryable || attempt >= c.backoff.Attempts {
+			return resp, statusCode, err
+		}
+
+		delay := c.backoff.delay(attempt - 1)
+		if err != nil {
+			log.Printf("Retrying %v %v in %v (attempt %d/%d): %v", req.Method, req.URL, delay, attempt, c.backoff.Attempts, err)
+		} else {
+			log.Printf("Retrying %v %v in %v (attempt %d/%d): received statusCode %d", req.Method, req.URL, delay, attempt, c.backoff.Attempts, statusCode)
+		}
+		timer := clock.OrSystem(c.clock).NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return "", 0, fmt.Errorf("retrying %v %v cancelled: %w", req.Method, req.URL, ctx.Err())
+		case <-timer.C():
+		}
+	}
+}
+
+func (c *HTTPClient) sendReq(req *http.Request, token string) (string, int, error) {
+	if req == nil {
+		return "", 0, errors.New("sendReq received a nil req (*http.Request)")
+	}
+
+	// Public APIs e.g. Aladhan don't need a token.
+	if token != "" {
+		req.Header.Add("Authorization", "Bearer "+token)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		err = fmt.Errorf("received an error on response for req %v: %w", req, err)
+		if isNetworkError(err) {
+			return "", 0, Transient(err)
+		}
+		return "", 0, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		err = fmt.Errorf("error while reading the response bytes for %v: %w", resp.Body, err)
+		if isNetworkError(err) {
+			return "", 0, Transient(err)
+		}
+		return "", 0, err
+	}
+
+	return string([]byte(body)), resp.StatusCode, nil
+}
+
+// Get sends a GET request, retrying transient errors. Cancelling ctx aborts
+// the request.
+func (c *HTTPClient) Get(ctx context.Context, addr string) (string, int, error) {
+	resp, statusCode, err := c.retry(ctx, func() (*http.Request, error) {
+		// Create a new request using http
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, addr, nil)
+		if err != nil {
+			return nil, fmt.Errorf("error on Get NewRequest: %w", err)
+		}
+		return req, nil
+	})
+	if err != nil {
+		return "", 0, fmt.Errorf("error while sending Get req %v to the httpClient: %w", addr, err)
+	}
+
+	return resp, statusCode, nil
+}
+
+// Post sends a POST request with a JSON payload, retrying transient errors.
+// Cancelling ctx aborts the request.
+func (c *HTTPClient) Post(ctx context.Context, addr string, payload map[string]string) (string, int, error) {
+	jsonload, err := json.Marshal(payload)
+	if err != nil {
+		return "", 0, fmt.Errorf("error on payload json marshal: %w", err)
+	}
+
+	resp, statusCode, err := c.retry(ctx, func() (*http.Request, error) {
+		// Create a new request using http. Each attempt needs a new body.
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, addr, strings.NewReader(string(jsonload)))
+		if err != nil {
+			return nil, fmt.Errorf("error on Post NewRequest: %w", err)
+		}
+		return req, nil
+	})
+	if err != nil {
+		return "", 0, fmt.Errorf("error while sending Post req %v to the httpClient: %w", addr, err)
+	}
+
+	return resp, statusCode, nil
+}
@@ -0,0 +1,176 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpclient
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"testing"
+	"time"
+)
+
+type httpclientMock struct {
+	err bool
+
+	statusCode int
+	resp       string
+}
+
+func (c *httpclientMock) Do(req *http.Request) (*http.Response, error) {
+	if c.err == true {
+		return nil, errors.New("error")
+	}
+
+	reader := ioutil.NopCloser(bytes.NewReader([]byte(c.resp)))
+
+	return &http.Response{
+		StatusCode: c.statusCode,
+		Body:       reader,
+	}, nil
+}
+
+func TestInvalidSendReq(t *testing.T) {
+	httpClient := &httpclientMock{err: true}
+	c := &HTTPClient{
+		client: httpClient,
+		token:  "test-token",
+	}
+	if _, _, err := c.Get(context.Background(), "test-address"); err == nil {
+		t.Errorf("Server side errors should be propagated. want err, got nil")
+	}
+	if _, _, err := c.Post(context.Background(), "test-address", map[string]string{"test": "test"}); err == nil {
+		t.Errorf("Server side errors should be propagated. want err, got nil")
+	}
+}
+
+func TestValidSendReq(t *testing.T) {
+	httpClient := &httpclientMock{statusCode: 200, resp: `{"key": "value"}`}
+	c := &HTTPClient{
+		client: httpClient,
+		token:  "test-token",
+	}
+	switch _, code, err := c.Get(context.Background(), "test-address"); {
+	case err != nil:
+		t.Fatalf("httpClient Get request should succeed. got err: %v", err)
+
+	case code != 200:
+		t.Errorf("httpClient Get request didn't return correct statusCode. got %v want %v", code, 200)
+	}
+
+	switch _, code, err := c.Post(context.Background(), "test-address", map[string]string{"test": "test"}); {
+	case err != nil:
+		t.Fatalf("httpClient Post request should succeed. got err: %v", err)
+	case code != 200:
+		t.Errorf("httpClient Post request didn't return correct statusCode. got %v want %v", code, 200)
+	}
+}
+
+// flakyClientMock responds with statusCodes in turn, 0 for a refused
+// connection and -1 for a request that can't be sent e.g. of a malformed URL.
+type flakyClientMock struct {
+	statusCodes []int
+	calls       int
+}
+
+func (c *flakyClientMock) Do(req *http.Request) (*http.Response, error) {
+	statusCode := c.statusCodes[c.calls]
+	c.calls++
+	switch statusCode {
+	case 0:
+		return nil, &url.Error{Op: "Post", URL: req.URL.String(), Err: &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}}
+	case -1:
+		return nil, &url.Error{Op: "Post", URL: req.URL.String(), Err: errors.New("unsupported protocol scheme")}
+	}
+	return &http.Response{
+		StatusCode: statusCode,
+		Body:       ioutil.NopCloser(bytes.NewReader([]byte("resp"))),
+	}, nil
+}
+
+func TestSendReqRetries(t *testing.T) {
+	for _, test := range []struct {
+		description string
+		statusCodes []int
+
+		wantCalls      int
+		wantStatusCode int
+		wantTransient  bool
+	}{
+		{
+			description:    "Succeeds after transient errors",
+			statusCodes:    []int{0, 503, 200},
+			wantCalls:      3,
+			wantStatusCode: 200,
+		},
+		{
+			description:    "Permanent status codes aren't retried",
+			statusCodes:    []int{401, 200},
+			wantCalls:      1,
+			wantStatusCode: 401,
+		},
+		{
+			description:    "Transient status codes are returned after the last attempt",
+			statusCodes:    []int{502, 502, 502},
+			wantCalls:      3,
+			wantStatusCode: 502,
+		},
+		{
+			description:   "Failed requests are transient errors after the last attempt",
+			statusCodes:   []int{0, 0, 0},
+			wantCalls:     3,
+			wantTransient: true,
+		},
+		{
+			description: "Requests that can't be sent aren't retried",
+			statusCodes: []int{-1, 200},
+			wantCalls:   1,
+		},
+	} {
+		t.Run(test.description, func(t *testing.T) {
+			mock := &flakyClientMock{statusCodes: test.statusCodes}
+			c := &HTTPClient{
+				client:  mock,
+				token:   "test-token",
+				backoff: Backoff{Attempts: 3, Initial: time.Millisecond, Max: 2 * time.Millisecond},
+			}
+
+			_, code, err := c.Post(context.Background(), "test-address", map[string]string{"test": "test"})
+			if IsTransient(err) != test.wantTransient {
+				t.Errorf("Post transient error mismatch. Got %v, want transient: %v", err, test.wantTransient)
+			}
+			if code != test.wantStatusCode {
+				t.Errorf("Post statusCode mismatch. Got %v, want %v", code, test.wantStatusCode)
+			}
+			if mock.calls != test.wantCalls {
+				t.Errorf("Post attempts mismatch. Got %v, want %v", mock.calls, test.wantCalls)
+			}
+		})
+	}
+}
+
+func TestBackoffDelay(t *testing.T) {
+	b := Backoff{Attempts: 5, Initial: time.Second, Max: 4 * time.Second}
+	for retry, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second} {
+		if got := b.delay(retry); got < want/2 || got > want {
+			t.Errorf("delay(%d) = %v, want within [%v, %v]", retry, got, want/2, want)
+		}
+	}
+}
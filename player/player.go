@@ -0,0 +1,194 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package player is the MP3 files handler. Reads the Adhan mp3 file and
+// Rewinds/Plays it.
+
+package player
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/hajimehoshi/go-mp3"
+	"github.com/hajimehoshi/oto/v2"
+	"github.com/ssafty/adhan-homeassistant-pi/clock"
+)
+
+// PLAYBACK_POLL is the interval to check whether a cancelled playback ended.
+const PLAYBACK_POLL = 5 * time.Second
+
+// AdhanPlayer plays an mp3 file on the local audio device.
+type AdhanPlayer struct {
+	player oto.Player
+
+	filePath      string
+	samplingRate  *int
+	numChannels   *int
+	audioBitDepth *int
+
+	// clock polls the playback. It defaults to the system clock.
+	clock clock.Clock
+}
+
+type AdhanPlayerOpt func(*AdhanPlayer)
+
+func FilePath(f string) AdhanPlayerOpt {
+	return func(a *AdhanPlayer) {
+		a.filePath = f
+	}
+}
+
+func SamplingRate(r int) AdhanPlayerOpt {
+	return func(a *AdhanPlayer) {
+		a.samplingRate = &r
+	}
+}
+
+func NumChannels(n int) AdhanPlayerOpt {
+	return func(a *AdhanPlayer) {
+		a.numChannels = &n
+	}
+}
+
+func AudioBitDepth(d int) AdhanPlayerOpt {
+	return func(a *AdhanPlayer) {
+		a.audioBitDepth = &d
+	}
+}
+
+// PlayerClock replaces the system clock polling the playback.
+func PlayerClock(c clock.Clock) AdhanPlayerOpt {
+	return func(a *AdhanPlayer) {
+		a.clock = c
+	}
+}
+
+func NewAdhanPlayer(opts ...AdhanPlayerOpt) (*AdhanPlayer, error) {
+	ap := &AdhanPlayer{}
+
+	for _, opt := range opts {
+		opt(ap)
+	}
+
+	switch {
+	case ap.filePath == "":
+		return nil, errors.New("NewAdhanPlayer's file path is not specified.")
+	case ap.audioBitDepth == nil:
+		return nil, errors.New("NewAdhanPlayer's AudioBitDepth is not specified.")
+	case ap.numChannels == nil:
+		return nil, errors.New("NewAdhanPlayer's NumChannels is not specified")
+	case ap.samplingRate == nil:
+		return nil, errors.New("NewAdhanPlayer's audioBitDepth is not specified")
+	}
+
+	// Load file into memory.
+	audioBytes, err := os.ReadFile(ap.filePath)
+	if err != nil {
+		return nil, fmt.Errorf("NewAdhanPlayer reading %s to bytes failed: %w", ap.filePath, err)
+	}
+
+	decoded, err := mp3.NewDecoder(bytes.NewReader(audioBytes))
+	if err != nil {
+		return nil, fmt.Errorf("NewAdhanPlayer decoding Audio Byte failed: %w", err)
+	}
+
+	otoCtx, err := newOtoContext(*ap.samplingRate, *ap.numChannels, *ap.audioBitDepth)
+	if err != nil {
+		return nil, fmt.Errorf("NewAdhanPlayer oto.NewContext creation failed: %w", err)
+	}
+
+	ap.player = otoCtx.NewPlayer(decoded)
+	return ap, nil
+}
+
+// otoContext is shared by all players e.g. the Adhan and chime players, because
+// oto only allows a single context per process.
+var otoContext struct {
+	ctx    *oto.Context
+	format [3]int
+}
+
+// newOtoContext returns the shared oto context. All players must use the same
+// audio format.
+func newOtoContext(samplingRate, numChannels, audioBitDepth int) (*oto.Context, error) {
+	format := [3]int{samplingRate, numChannels, audioBitDepth}
+	if otoContext.ctx != nil {
+		if otoContext.format != format {
+			return nil, fmt.Errorf("audio format %v differs from the format %v of the other players", format, otoContext.format)
+		}
+		return otoContext.ctx, nil
+	}
+
+	ctx, readyChan, err := oto.NewContext(samplingRate, numChannels, audioBitDepth)
+	if err != nil {
+		return nil, err
+	}
+	<-readyChan
+
+	otoContext.ctx, otoContext.format = ctx, format
+	return ctx, nil
+}
+
+func (a *AdhanPlayer) Play(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("AdhanPlayer play cancelled: %w", err)
+	}
+	_, err := a.player.(io.Seeker).Seek(0, io.SeekStart)
+	if err != nil {
+		return fmt.Errorf("AdhanPlayer rewind failed: %w", err)
+	}
+	log.Printf("Playing %v.", a.filePath)
+	a.player.Play()
+	go a.stopOnCancel(ctx)
+	return nil
+}
+
+// stopOnCancel stops the playback once ctx is cancelled. It returns when the
+// playback ended.
+func (a *AdhanPlayer) stopOnCancel(ctx context.Context) {
+	c := clock.OrSystem(a.clock)
+	for a.player.IsPlaying() {
+		timer := c.NewTimer(PLAYBACK_POLL)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			a.Stop()
+			return
+		case <-timer.C():
+		}
+	}
+}
+
+// Stop pauses the playback. The next Play rewinds.
+func (a *AdhanPlayer) Stop() {
+	if a.player.IsPlaying() {
+		log.Printf("Stopping %v.", a.filePath)
+		a.player.Pause()
+	}
+}
+
+func (a *AdhanPlayer) IsPlaying() bool {
+	if ip := a.player.IsPlaying(); ip {
+		log.Println("AdhanPlayer is currently playing.")
+		return true
+	}
+	return false
+}
@@ -0,0 +1,377 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Online prayer times. Fetches a month of timings at a time from an
+// Aladhan-compatible REST API (https://aladhan.com/prayer-times-api) and
+// caches it to disk, so a month is only fetched once and keeps being served
+// when the network is down.
+
+package prayertimes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ssafty/adhan-homeassistant-pi/clock"
+	"github.com/ssafty/adhan-homeassistant-pi/httpclient"
+	"github.com/ssafty/adhan-homeassistant-pi/internal/atomicfile"
+)
+
+const (
+	ALADHAN_URL     = "https://api.aladhan.com"
+	ALADHAN_TIMEOUT = 30 * time.Second
+	// ALADHAN_COOLDOWN is how long a month that failed to load isn't fetched
+	// again, so the fallback serves it without a request per call while the
+	// network is down.
+	ALADHAN_COOLDOWN = 5 * time.Minute
+)
+
+// aladhanMethods maps the calculation methods to Aladhan's method ids.
+var aladhanMethods = map[string]int{
+	"jafari":      0,
+	"karachi":     1,
+	"isna":        2,
+	"mwl":         3,
+	"umm_al_qura": 4,
+	"egypt":       5,
+	"tehran":      7,
+	CUSTOM_METHOD: 99,
+}
+
+// aladhanTimings maps Aladhan's timing names to timetableColumns.
+var aladhanTimings = map[string]string{
+	"Fajr":    "fajr",
+	"Sunrise": "sunrise",
+	"Dhuhr":   "dhuhr",
+	"Asr":     "asr",
+	"Maghrib": "maghrib",
+	"Isha":    "isha",
+}
+
+type AladhanParams struct {
+	// BaseURL of the API e.g. ALADHAN_URL or a local test server.
+	BaseURL string
+	// CacheDir stores a JSON file per fetched month.
+	CacheDir string
+
+	Latitude  float64
+	Longitude float64
+	Method    CalcMethod
+	AsrFactor int
+}
+
+// aladhanResponse is the subset of the calendar response used.
+type aladhanResponse struct {
+	Code   int    `json:"code"`
+	Status string `json:"status"`
+	Data   []struct {
+		Timings map[string]string `json:"timings"`
+		Date    struct {
+			Gregorian struct {
+				// Date is formatted as dd-mm-yyyy.
+				Date string `json:"date"`
+			} `json:"gregorian"`
+		} `json:"date"`
+	} `json:"data"`
+}
+
+// AladhanPrayerTimes extends TimetablePrayerTimes with the fetched months.
+type AladhanPrayerTimes struct {
+	TimetablePrayerTimes
+
+	client *httpclient.HTTPClient
+	params AladhanParams
+	// failed are the months that failed to load e.g. 2024-03, until their
+	// cooldown ends.
+	failed map[string]failedMonth
+	// zone is the IANA name of the timezone requested from Aladhan e.g.
+	// "Europe/Berlin", resolved on the first load.
+	zone string
+}
+
+// failedMonth is the error of a month that failed to load.
+type failedMonth struct {
+	at  time.Time
+	err error
+}
+
+func NewAladhanPrayerTimes(params AladhanParams, opts ...PrayerTimesOpt) (*AladhanPrayerTimes, error) {
+	if params.BaseURL == "" {
+		params.BaseURL = ALADHAN_URL
+	}
+	if params.Method.fajrAngle == 0 {
+		params.Method = calcMethods[DEFAULT_METHOD]
+	}
+
+	switch {
+	case params.CacheDir == "":
+		return nil, errors.New("NewAladhanPrayerTimes's cache directory is not specified.")
+	case params.Latitude < -90 || params.Latitude > 90:
+		return nil, fmt.Errorf("NewAladhanPrayerTimes's latitude %v is out of range [-90, 90].", params.Latitude)
+	case params.Longitude < -180 || params.Longitude > 180:
+		return nil, fmt.Errorf("NewAladhanPrayerTimes's longitude %v is out of range [-180, 180].", params.Longitude)
+	case params.AsrFactor != 0 && params.AsrFactor != 1 && params.AsrFactor != 2:
+		return nil, fmt.Errorf("NewAladhanPrayerTimes's asr factor %d should be 1 (Shafi'i) or 2 (Hanafi).", params.AsrFactor)
+	}
+	if _, ok := aladhanMethods[methodKey(params.Method)]; !ok {
+		return nil, fmt.Errorf("NewAladhanPrayerTimes's calculation method %q is not supported by Aladhan.", params.Method)
+	}
+	if err := os.MkdirAll(params.CacheDir, 0755); err != nil {
+		return nil, fmt.Errorf("NewAladhanPrayerTimes creating the cache directory failed: %w", err)
+	}
+
+	pt := &AladhanPrayerTimes{
+		TimetablePrayerTimes: TimetablePrayerTimes{days: map[string]timetableRow{}},
+		client:               httpclient.NewHTTPClient("", httpclient.Client(&http.Client{Timeout: ALADHAN_TIMEOUT}), httpclient.Retries(httpclient.Backoff{})),
+		params:               params,
+	}
+	for _, opt := range opts {
+		opt(&pt.PrayerTimes)
+	}
+
+	now := clock.OrSystem(pt.clock).Now()
+	if err := pt.GetTodayPrayerTimes(context.Background(), now); err != nil {
+		return nil, fmt.Errorf("Error initializing NewAladhanPrayerTimes for %s: %w", now.Format(DATE_LAYOUT), err)
+	}
+	return pt, nil
+}
+
+// GetTodayPrayerTimes reads today's prayer times from the fetched months.
+func (p *AladhanPrayerTimes) GetTodayPrayerTimes(ctx context.Context, now time.Time) error {
+	now = p.in(now)
+	if !p.Date.IsZero() && p.Date == GetDate(now) {
+		return nil
+	}
+
+	if err := p.Load(ctx, now, p); err != nil {
+		return err
+	}
+
+	log.Printf("PrayerTimes today (Aladhan method: %v): %v", p.params.Method, p.PrayerTimes)
+	return nil
+}
+
+// GetPrayerTimes reads the prayer times of the day of a timestamp.
+func (p *AladhanPrayerTimes) GetPrayerTimes(ctx context.Context, day time.Time) (*PrayerTimes, error) {
+	return p.adjust(p.PrayerTimesOn(ctx, day))
+}
+
+// PrayerTimesOn fetches the month of a day, unless it is loaded, and reads the
+// day like a timetable. ctx bounds the request.
+func (p *AladhanPrayerTimes) PrayerTimesOn(ctx context.Context, day time.Time) (*PrayerTimes, error) {
+	day = p.in(day)
+	if _, ok := p.days[day.Format(DATE_LAYOUT)]; !ok {
+		if err := p.loadMonth(ctx, day.Year(), day.Month()); err != nil {
+			if p.fallback == nil {
+				return nil, err
+			}
+			log.Printf("Failed to load the Aladhan prayer times of %s: %v", day.Format("2006-01"), err)
+		}
+	}
+	return p.TimetablePrayerTimes.PrayerTimesOn(ctx, day)
+}
+
+// loadMonth adds the days of a month from the cache or, if not cached, from the
+// API to the timetable. A month that failed to load returns the same error
+// until its ALADHAN_COOLDOWN ends.
+func (p *AladhanPrayerTimes) loadMonth(ctx context.Context, year int, month time.Month) error {
+	key := fmt.Sprintf("%d-%02d", year, month)
+	now := clock.OrSystem(p.clock).Now()
+	if failed, ok := p.failed[key]; ok && now.Sub(failed.at) < ALADHAN_COOLDOWN {
+		return failed.err
+	}
+	if p.zone == "" {
+		zone, err := timezoneName(p.timezone)
+		if err != nil {
+			return err
+		}
+		p.zone = zone
+	}
+
+	cacheFpath := filepath.Join(p.params.CacheDir, p.cacheName(year, month))
+	rows, err := p.readCache(cacheFpath)
+	if err != nil {
+		if rows, err = p.fetchMonth(ctx, year, month, cacheFpath, err); err != nil {
+			// A cancelled request isn't a failure of the month.
+			if ctx.Err() == nil {
+				if p.failed == nil {
+					p.failed = map[string]failedMonth{}
+				}
+				p.failed[key] = failedMonth{at: now, err: err}
+			}
+			return err
+		}
+	}
+	delete(p.failed, key)
+
+	days, err := newTimetableDays(rows)
+	if err != nil {
+		return err
+	}
+	for date, row := range days {
+		p.days[date] = row
+	}
+	return nil
+}
+
+// fetchMonth fetches the rows of a month that is not cached from the API and
+// caches them.
+func (p *AladhanPrayerTimes) fetchMonth(ctx context.Context, year int, month time.Month, cacheFpath string, cacheErr error) ([]timetableRow, error) {
+	addr := p.monthURL(year, month)
+	log.Printf("Fetching the Aladhan prayer times of %d-%02d (not cached: %v)", year, month, cacheErr)
+
+	resp, statusCode, err := p.client.Get(ctx, addr)
+	if err != nil {
+		return nil, fmt.Errorf("encountered error from Get(%s) request and no cache: %w", addr, err)
+	}
+	if statusCode != 200 {
+		return nil, fmt.Errorf("unsuccessful response status code. Received statusCode: %d for Get(%s): %v", statusCode, addr, resp)
+	}
+	rows, err := parseAladhanMonth([]byte(resp), addr)
+	if err != nil {
+		return nil, err
+	}
+	if err := atomicfile.Write(cacheFpath, []byte(resp)); err != nil {
+		// The prayer times are still usable without the cache.
+		log.Printf("Warning: failed to cache the Aladhan prayer times: %v", err)
+	}
+	return rows, nil
+}
+
+// readCache reads the rows of a cached month.
+func (p *AladhanPrayerTimes) readCache(fpath string) ([]timetableRow, error) {
+	body, err := os.ReadFile(fpath)
+	if err != nil {
+		return nil, err
+	}
+	return parseAladhanMonth(body, fpath)
+}
+
+// monthURL returns the calendar URL of a month e.g.
+// https://api.aladhan.com/v1/calendar/2024/3?latitude=48.1374&longitude=11.5755&method=3&school=0&timezonestring=Europe%2FBerlin
+func (p *AladhanPrayerTimes) monthURL(year int, month time.Month) string {
+	query := url.Values{}
+	query.Set("latitude", fmt.Sprint(p.params.Latitude))
+	query.Set("longitude", fmt.Sprint(p.params.Longitude))
+	key := methodKey(p.params.Method)
+	query.Set("method", fmt.Sprint(aladhanMethods[key]))
+	if key == CUSTOM_METHOD {
+		// Fajr angle, Maghrib (unused) and Ishaa angle or interval.
+		isha := fmt.Sprint(p.params.Method.ishaAngle)
+		if p.params.Method.ishaInterval > 0 {
+			isha = fmt.Sprintf("%d min", int(p.params.Method.ishaInterval.Minutes()))
+		}
+		query.Set("methodSettings", fmt.Sprintf("%v,null,%v", p.params.Method.fajrAngle, isha))
+	}
+	// school is 0 for Shafi'i and 1 for Hanafi Asr.
+	school := 0
+	if p.params.AsrFactor == 2 {
+		school = 1
+	}
+	query.Set("school", fmt.Sprint(school))
+	// Otherwise Aladhan infers the timezone from the coordinates, which may
+	// not be the one the times are parsed in.
+	query.Set("timezonestring", p.zone)
+	return fmt.Sprintf("%s/v1/calendar/%d/%d?%s", strings.TrimRight(p.params.BaseURL, "/"), year, month, query.Encode())
+}
+
+// cacheName returns the cache file name of a month. It includes the parameters
+// and the timezone so a changed location, method or timezone isn't served from
+// a stale cache.
+func (p *AladhanPrayerTimes) cacheName(year int, month time.Month) string {
+	key := methodKey(p.params.Method)
+	zone := strings.ReplaceAll(p.zone, "/", "-")
+	name := fmt.Sprintf("aladhan_%.4f_%.4f_%s_%d_%s_%d-%02d.json",
+		p.params.Latitude, p.params.Longitude, key, p.params.AsrFactor, zone, year, month)
+	if key == CUSTOM_METHOD {
+		name = fmt.Sprintf("aladhan_%.4f_%.4f_%v_%v_%v_%d_%s_%d-%02d.json", p.params.Latitude, p.params.Longitude,
+			p.params.Method.fajrAngle, p.params.Method.ishaAngle, p.params.Method.ishaInterval, p.params.AsrFactor, zone, year, month)
+	}
+	return name
+}
+
+// timezoneName returns the IANA name of a timezone e.g. "Europe/Berlin". The
+// local timezone, also used if loc is nil, is resolved from $TZ or the
+// /etc/localtime symlink.
+func timezoneName(loc *time.Location) (string, error) {
+	if loc == nil {
+		loc = time.Local
+	}
+	if name := loc.String(); name != "Local" {
+		return name, nil
+	}
+	if tz := strings.TrimPrefix(os.Getenv("TZ"), ":"); tz != "" {
+		return tz, nil
+	}
+	if target, err := filepath.EvalSymlinks("/etc/localtime"); err == nil {
+		if _, name, ok := strings.Cut(target, "zoneinfo/"); ok {
+			return name, nil
+		}
+	}
+	return "", errors.New("failed to resolve the name of the local timezone for Aladhan. Set it by --timezone e.g. Europe/Berlin.")
+}
+
+// methodKey returns the --calc_method name of a calculation method. Methods
+// that are not registered in calcMethods are custom.
+func methodKey(m CalcMethod) string {
+	for key, registered := range calcMethods {
+		if registered == m {
+			return key
+		}
+	}
+	return CUSTOM_METHOD
+}
+
+// parseAladhanMonth converts a calendar response to timetable rows.
+func parseAladhanMonth(body []byte, name string) ([]timetableRow, error) {
+	resp := aladhanResponse{}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("%s: error decoding JSON: %w", name, err)
+	}
+	if resp.Code != 200 || len(resp.Data) == 0 {
+		return nil, fmt.Errorf("%s: unsuccessful response code %d: %s", name, resp.Code, resp.Status)
+	}
+
+	rows := []timetableRow{}
+	for i, day := range resp.Data {
+		date, err := time.Parse("02-01-2006", day.Date.Gregorian.Date)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid date %q: %w", name, i+1, day.Date.Gregorian.Date, err)
+		}
+		row := timetableRow{file: name, line: i + 1, date: date.Format(DATE_LAYOUT), times: map[string]string{}}
+		for timing, column := range aladhanTimings {
+			// Timings have a timezone suffix e.g. "05:09 (CET)".
+			if fields := strings.Fields(day.Timings[timing]); len(fields) > 0 {
+				row.times[column] = fields[0]
+			}
+		}
+		if err := assertColumns(row.position(""), func(c string) bool {
+			_, ok := row.times[c]
+			return ok || c == "date"
+		}); err != nil {
+			return nil, err
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
@@ -0,0 +1,272 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prayertimes
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ssafty/adhan-homeassistant-pi/clock"
+	"github.com/ssafty/adhan-homeassistant-pi/httpclient"
+)
+
+// aladhanServer serves a calendar month with the same timings every day and
+// counts the requests per path.
+func aladhanServer(t *testing.T) (*httptest.Server, map[string]int) {
+	t.Helper()
+	requests := map[string]int{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests[r.URL.Path]++
+
+		var year, month int
+		if _, err := fmt.Sscanf(r.URL.Path, "/v1/calendar/%d/%d", &year, &month); err != nil {
+			http.Error(w, `{"code": 404, "status": "Not Found"}`, http.StatusNotFound)
+			return
+		}
+		if r.URL.Query().Get("latitude") == "" || r.URL.Query().Get("method") != "3" {
+			http.Error(w, `{"code": 400, "status": "Bad Request"}`, http.StatusBadRequest)
+			return
+		}
+
+		data := []map[string]any{}
+		first := time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC)
+		for d := first; d.Month() == first.Month(); d = d.AddDate(0, 0, 1) {
+			data = append(data, map[string]any{
+				"timings": map[string]string{
+					"Fajr": "05:09 (CET)", "Sunrise": "06:54 (CET)", "Dhuhr": "12:28 (CET)", "Asr": "15:26 (CET)",
+					"Sunset": "18:00 (CET)", "Maghrib": "18:02 (CET)", "Isha": "19:41 (CET)", "Imsak": "04:59 (CET)",
+				},
+				"date": map[string]any{"gregorian": map[string]string{"date": d.Format("02-01-2006")}},
+			})
+		}
+		json.NewEncoder(w).Encode(map[string]any{"code": 200, "status": "OK", "data": data})
+	}))
+	t.Cleanup(server.Close)
+	return server, requests
+}
+
+func TestAladhanPrayerTimes(t *testing.T) {
+	server, requests := aladhanServer(t)
+	params := AladhanParams{
+		BaseURL:   server.URL,
+		CacheDir:  t.TempDir(),
+		Latitude:  MUNICH_LATITUDE,
+		Longitude: MUNICH_LONGITUDE,
+	}
+
+	p, err := NewAladhanPrayerTimes(params)
+	if err != nil {
+		t.Fatalf("NewAladhanPrayerTimes returned error, expected None: %v", err)
+	}
+
+	// The last day of a month needs the next month too.
+	day := time.Date(2024, time.March, 31, 10, 0, 0, 0, time.UTC)
+	if err := p.GetTodayPrayerTimes(context.Background(), day); err != nil {
+		t.Fatalf("GetTodayPrayerTimes returned error, expected None: %v", err)
+	}
+	for _, test := range []struct {
+		got  *Prayer
+		want string
+	}{
+		{p.Fajr, "05:09"},
+		{p.Sunrise, "06:54"},
+		{p.Dhuhr, "12:28"},
+		{p.Asr, "15:26"},
+		{p.Maghrib, "18:02"},
+		{p.Ishaa, "19:41"},
+	} {
+		if got := test.got.Time.Format(TIME_LAYOUT); got != test.want || !isSameDay(day, test.got.Time) {
+			t.Errorf("%v mismatch. Got %v, want %v on %v", test.got.Name, test.got.Time, test.want, day.Format(DATE_LAYOUT))
+		}
+	}
+	for _, path := range []string{"/v1/calendar/2024/3", "/v1/calendar/2024/4"} {
+		if requests[path] != 1 {
+			t.Errorf("Requests of %v mismatch. Got %v, want 1", path, requests[path])
+		}
+	}
+
+	t.Run("Serves fetched months from the cache when the network is down", func(t *testing.T) {
+		server.Close()
+
+		// Today's months were fetched by the first NewAladhanPrayerTimes.
+		cached, err := NewAladhanPrayerTimes(params)
+		if err != nil {
+			t.Fatalf("NewAladhanPrayerTimes returned error, expected None: %v", err)
+		}
+		if err := cached.GetTodayPrayerTimes(context.Background(), day); err != nil {
+			t.Fatalf("GetTodayPrayerTimes returned error, expected None: %v", err)
+		}
+		if got := cached.Fajr.Time.Format(TIME_LAYOUT); got != "05:09" {
+			t.Errorf("Fajr mismatch. Got %v, want 05:09", got)
+		}
+
+		if err := cached.GetTodayPrayerTimes(context.Background(), time.Date(2024, time.June, 10, 10, 0, 0, 0, time.UTC)); err == nil {
+			t.Errorf("GetTodayPrayerTimes of an uncached month should fail when the network is down. Got none.")
+		}
+	})
+}
+
+func TestAladhanPrayerTimesFallback(t *testing.T) {
+	calculated := &CalculatedPrayerTimes{params: munichParams}
+	p := &AladhanPrayerTimes{
+		TimetablePrayerTimes: TimetablePrayerTimes{days: map[string]timetableRow{}},
+		client:               httpclient.NewHTTPClient("", httpclient.Client(http.DefaultClient), httpclient.Retries(httpclient.Backoff{})),
+		// Nothing listens on port 1.
+		params: AladhanParams{BaseURL: "http://127.0.0.1:1", CacheDir: t.TempDir(), Method: calcMethods[DEFAULT_METHOD]},
+	}
+	Fallback(calculated)(&p.PrayerTimes)
+
+	if err := p.GetTodayPrayerTimes(context.Background(), time.Date(2024, time.March, 10, 10, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatalf("GetTodayPrayerTimes returned error, expected None: %v", err)
+	}
+	want, err := calculated.PrayerTimesOn(context.Background(), time.Date(2024, time.March, 10, 12, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("PrayerTimesOn returned error, expected None: %v", err)
+	}
+	if !p.Fajr.Time.Equal(want.Fajr.Time) {
+		t.Errorf("Fajr mismatch. Got %v, want the calculated %v", p.Fajr.Time, want.Fajr.Time)
+	}
+}
+
+func TestAladhanPrayerTimesCooldown(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		http.Error(w, `{"code": 503, "status": "Service Unavailable"}`, http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(server.Close)
+
+	fake := clock.NewFake(time.Date(2024, time.March, 10, 10, 0, 0, 0, time.UTC))
+	p := &AladhanPrayerTimes{
+		TimetablePrayerTimes: TimetablePrayerTimes{days: map[string]timetableRow{}},
+		client:               httpclient.NewHTTPClient("", httpclient.Client(http.DefaultClient), httpclient.Retries(httpclient.Backoff{})),
+		params:               AladhanParams{BaseURL: server.URL, CacheDir: t.TempDir(), Method: calcMethods[DEFAULT_METHOD]},
+	}
+	Fallback(&CalculatedPrayerTimes{params: munichParams})(&p.PrayerTimes)
+	PrayerTimesClock(fake)(&p.PrayerTimes)
+
+	for _, test := range []struct {
+		description  string
+		advance      time.Duration
+		wantRequests int
+	}{
+		{description: "Fetches the month once", wantRequests: 1},
+		{description: "Doesn't refetch during the cooldown", advance: ALADHAN_COOLDOWN - time.Second, wantRequests: 1},
+		{description: "Refetches after the cooldown", advance: time.Second, wantRequests: 2},
+	} {
+		t.Run(test.description, func(t *testing.T) {
+			fake.Advance(test.advance)
+			if _, err := p.GetPrayerTimes(context.Background(), fake.Now()); err != nil {
+				t.Fatalf("GetPrayerTimes returned error, expected the fallback: %v", err)
+			}
+			if requests != test.wantRequests {
+				t.Errorf("Requests mismatch. Got %v, want %v", requests, test.wantRequests)
+			}
+		})
+	}
+
+	t.Run("Cancelled request isn't remembered", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if err := p.loadMonth(ctx, 2024, time.May); err == nil {
+			t.Fatalf("loadMonth with a cancelled context should return an error. Got none.")
+		}
+		if _, ok := p.failed["2024-05"]; ok {
+			t.Errorf("A cancelled request shouldn't start the cooldown of its month.")
+		}
+	})
+}
+
+func TestInvalidAladhanPrayerTimes(t *testing.T) {
+	server, _ := aladhanServer(t)
+
+	for _, test := range []struct {
+		description string
+		params      AladhanParams
+	}{
+		{
+			description: "Missing cache directory",
+			params:      AladhanParams{BaseURL: server.URL},
+		},
+		{
+			description: "Latitude out of range",
+			params:      AladhanParams{BaseURL: server.URL, CacheDir: t.TempDir(), Latitude: 91},
+		},
+		{
+			description: "API error",
+			params:      AladhanParams{BaseURL: server.URL, CacheDir: t.TempDir(), Method: calcMethods["isna"]},
+		},
+	} {
+		t.Run(test.description, func(t *testing.T) {
+			if _, err := NewAladhanPrayerTimes(test.params); err == nil {
+				t.Errorf("NewAladhanPrayerTimes should return an error. Got none.")
+			}
+		})
+	}
+
+	t.Run("Malformed cache is refetched", func(t *testing.T) {
+		p := &AladhanPrayerTimes{
+			TimetablePrayerTimes: TimetablePrayerTimes{days: map[string]timetableRow{}},
+			client:               httpclient.NewHTTPClient("", httpclient.Client(http.DefaultClient), httpclient.Retries(httpclient.Backoff{})),
+			params:               AladhanParams{BaseURL: server.URL, CacheDir: t.TempDir(), Method: calcMethods[DEFAULT_METHOD]},
+			zone:                 "UTC",
+		}
+		cacheFpath := filepath.Join(p.params.CacheDir, p.cacheName(2024, time.March))
+		if err := os.WriteFile(cacheFpath, []byte("{"), 0644); err != nil {
+			t.Fatalf("Failed to write the cache: %v", err)
+		}
+		if err := p.loadMonth(context.Background(), 2024, time.March); err != nil {
+			t.Fatalf("loadMonth returned error, expected None: %v", err)
+		}
+		if body, err := os.ReadFile(cacheFpath); err != nil || !strings.Contains(string(body), `"code":200`) {
+			t.Errorf("Cache should be rewritten. Got %q, %v", body, err)
+		}
+	})
+}
+
+func TestAladhanPrayerTimesTimezone(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatalf("Failed to load location: %v", err)
+	}
+	if got, err := timezoneName(berlin); err != nil || got != "Europe/Berlin" {
+		t.Errorf("timezoneName mismatch. Got %q (%v), want Europe/Berlin", got, err)
+	}
+	if time.Local.String() == "Local" {
+		t.Setenv("TZ", "Asia/Tokyo")
+		if got, err := timezoneName(nil); err != nil || got != "Asia/Tokyo" {
+			t.Errorf("timezoneName of the local timezone mismatch. Got %q (%v), want Asia/Tokyo", got, err)
+		}
+	}
+
+	params := AladhanParams{BaseURL: ALADHAN_URL, Latitude: MUNICH_LATITUDE, Longitude: MUNICH_LONGITUDE, Method: calcMethods[DEFAULT_METHOD]}
+	p := &AladhanPrayerTimes{params: params, zone: "Europe/Berlin"}
+	if got := p.monthURL(2024, time.March); !strings.Contains(got, "timezonestring=Europe%2FBerlin") {
+		t.Errorf("monthURL %v should request the timezone Europe/Berlin", got)
+	}
+	tokyo := &AladhanPrayerTimes{params: params, zone: "Asia/Tokyo"}
+	if p.cacheName(2024, time.March) == tokyo.cacheName(2024, time.March) {
+		t.Errorf("Months of different timezones should be cached apart. Both are %v", p.cacheName(2024, time.March))
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Registry of the calculation conventions used by the prayer times calculator.
+// Conventions differ in the twilight angles of Fajr and Ishaa, and some of them
+// use a fixed interval after Maghrib for Ishaa.
+
+package prayertimes
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const CUSTOM_METHOD = "custom"
+
+// CalcMethod are the twilight angles of a calculation method, see
+// GetCalcMethod.
+type CalcMethod struct {
+	name string
+
+	fajrAngle float64
+	// ishaAngle is ignored if ishaInterval is set.
+	ishaAngle    float64
+	ishaInterval time.Duration
+	// maghribAngle of 0 means Maghrib is at sunset.
+	maghribAngle float64
+}
+
+func (m CalcMethod) String() string {
+	return m.name
+}
+
+// calcMethods maps the --calc_method flag values to their conventions.
+// source: http://praytimes.org/wiki/Calculation_Methods
+var calcMethods = map[string]CalcMethod{
+	"mwl": {
+		name:      "Muslim World League",
+		fajrAngle: 18,
+		ishaAngle: 17,
+	},
+	"isna": {
+		name:      "Islamic Society of North America",
+		fajrAngle: 15,
+		ishaAngle: 15,
+	},
+	"egypt": {
+		name:      "Egyptian General Authority of Survey",
+		fajrAngle: 19.5,
+		ishaAngle: 17.5,
+	},
+	"umm_al_qura": {
+		name:         "Umm al-Qura University, Makkah",
+		fajrAngle:    18.5,
+		ishaInterval: 90 * time.Minute,
+	},
+	"karachi": {
+		name:      "University of Islamic Sciences, Karachi",
+		fajrAngle: 18,
+		ishaAngle: 18,
+	},
+	"tehran": {
+		name:         "Institute of Geophysics, University of Tehran",
+		fajrAngle:    17.7,
+		ishaAngle:    14,
+		maghribAngle: 4.5,
+	},
+	"jafari": {
+		name:         "Shia Ithna-Ashari, Leva Institute, Qum",
+		fajrAngle:    16,
+		ishaAngle:    14,
+		maghribAngle: 4,
+	},
+}
+
+const DEFAULT_METHOD = "mwl"
+
+// GetCalcMethod returns the named calculation convention. The custom method is
+// built from the explicit Fajr/Ishaa angles or the Ishaa interval.
+func GetCalcMethod(name string, fajrAngle, ishaAngle float64, ishaInterval time.Duration) (CalcMethod, error) {
+	if name != CUSTOM_METHOD {
+		m, ok := calcMethods[name]
+		if !ok {
+			return CalcMethod{}, fmt.Errorf("unknown calculation method %q. Supported methods: %v", name, CalcMethodNames())
+		}
+		return m, nil
+	}
+
+	switch {
+	case fajrAngle <= 0:
+		return CalcMethod{}, errors.New("custom calculation method expects a positive Fajr angle.")
+	case ishaAngle <= 0 && ishaInterval <= 0:
+		return CalcMethod{}, errors.New("custom calculation method expects a positive Ishaa angle or interval.")
+	case ishaAngle > 0 && ishaInterval > 0:
+		return CalcMethod{}, errors.New("custom calculation method expects either an Ishaa angle or an interval, not both.")
+	}
+
+	name = fmt.Sprintf("Custom (Fajr %v°, Ishaa %v°)", fajrAngle, ishaAngle)
+	if ishaInterval > 0 {
+		name = fmt.Sprintf("Custom (Fajr %v°, Ishaa Maghrib+%v)", fajrAngle, ishaInterval)
+	}
+	return CalcMethod{
+		name:         name,
+		fajrAngle:    fajrAngle,
+		ishaAngle:    ishaAngle,
+		ishaInterval: ishaInterval,
+	}, nil
+}
+
+// CalcMethodNames returns the sorted flag values of all supported methods.
+func CalcMethodNames() string {
+	names := []string{CUSTOM_METHOD}
+	for n := range calcMethods {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
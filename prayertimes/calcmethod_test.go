@@ -0,0 +1,144 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prayertimes
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGetCalcMethod(t *testing.T) {
+	for _, test := range []struct {
+		description  string
+		name         string
+		fajrAngle    float64
+		ishaAngle    float64
+		ishaInterval time.Duration
+
+		wantErr bool
+	}{
+		{
+			description: "Named method",
+			name:        "isna",
+		},
+		{
+			description: "Unknown method",
+			name:        "unknown",
+			wantErr:     true,
+		},
+		{
+			description: "Custom method with angles",
+			name:        CUSTOM_METHOD,
+			fajrAngle:   12,
+			ishaAngle:   12,
+		},
+		{
+			description:  "Custom method with Ishaa interval",
+			name:         CUSTOM_METHOD,
+			fajrAngle:    18,
+			ishaInterval: 90 * time.Minute,
+		},
+		{
+			description: "Custom method without Fajr angle",
+			name:        CUSTOM_METHOD,
+			ishaAngle:   17,
+			wantErr:     true,
+		},
+		{
+			description: "Custom method without Ishaa angle or interval",
+			name:        CUSTOM_METHOD,
+			fajrAngle:   18,
+			wantErr:     true,
+		},
+		{
+			description:  "Custom method with both Ishaa angle and interval",
+			name:         CUSTOM_METHOD,
+			fajrAngle:    18,
+			ishaAngle:    17,
+			ishaInterval: 90 * time.Minute,
+			wantErr:      true,
+		},
+	} {
+		t.Run(test.description, func(t *testing.T) {
+			_, err := GetCalcMethod(test.name, test.fajrAngle, test.ishaAngle, test.ishaInterval)
+			if gotErr := err != nil; gotErr != test.wantErr {
+				t.Errorf("GetCalcMethod error mismatch. Got %v, want error: %v", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestCalcMethods(t *testing.T) {
+	day := time.Date(2023, time.January, 10, 12, 0, 0, 0, time.UTC)
+	mwl, err := (&CalculatedPrayerTimes{params: munichParams}).calculate(day)
+	if err != nil {
+		t.Fatalf("calculate returned error, expected None: %v", err)
+	}
+
+	for _, test := range []struct {
+		method string
+
+		wantEarlierFajr bool
+		wantLaterIsha   bool
+	}{
+		{method: "isna"},
+		{method: "egypt", wantEarlierFajr: true, wantLaterIsha: true},
+		{method: "umm_al_qura", wantEarlierFajr: true},
+		{method: "karachi", wantLaterIsha: true},
+	} {
+		t.Run(test.method, func(t *testing.T) {
+			params := munichParams
+			params.Method = calcMethods[test.method]
+			got, err := (&CalculatedPrayerTimes{params: params}).calculate(day)
+			if err != nil {
+				t.Fatalf("calculate returned error, expected None: %v", err)
+			}
+
+			if earlier := got.fajr.Before(mwl.fajr); earlier != test.wantEarlierFajr {
+				t.Errorf("Fajr %v compared to MWL's %v: earlier=%v, want %v", got.fajr, mwl.fajr, earlier, test.wantEarlierFajr)
+			}
+			if later := got.isha.After(mwl.isha); later != test.wantLaterIsha {
+				t.Errorf("Ishaa %v compared to MWL's %v: later=%v, want %v", got.isha, mwl.isha, later, test.wantLaterIsha)
+			}
+			if !got.maghrib.Equal(mwl.maghrib) {
+				t.Errorf("Maghrib should be at sunset. Got %v, want %v", got.maghrib, mwl.maghrib)
+			}
+		})
+	}
+
+	t.Run("umm_al_qura Ishaa interval", func(t *testing.T) {
+		params := munichParams
+		params.Method = calcMethods["umm_al_qura"]
+		got, err := (&CalculatedPrayerTimes{params: params}).calculate(day)
+		if err != nil {
+			t.Fatalf("calculate returned error, expected None: %v", err)
+		}
+		if want := got.maghrib.Add(90 * time.Minute); !got.isha.Equal(want) {
+			t.Errorf("Ishaa mismatch. Got %v, want %v", got.isha, want)
+		}
+	})
+
+	t.Run("tehran Maghrib after sunset", func(t *testing.T) {
+		params := munichParams
+		params.Method = calcMethods["tehran"]
+		got, err := (&CalculatedPrayerTimes{params: params}).calculate(day)
+		if err != nil {
+			t.Fatalf("calculate returned error, expected None: %v", err)
+		}
+		if !got.maghrib.After(got.sunset) {
+			t.Errorf("Maghrib %v should be after sunset %v", got.maghrib, got.sunset)
+		}
+	})
+}
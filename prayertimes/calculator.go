@@ -0,0 +1,427 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Astronomical prayer times calculator. Computes the daily prayer times for any
+// latitude/longitude from the sun's declination, the equation of time and the
+// hour angles of the twilight/sunrise/sunset depression angles.
+// Based on the algorithms published by http://praytimes.org/calculation
+
+package prayertimes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"github.com/ssafty/adhan-homeassistant-pi/clock"
+)
+
+type HighLatitudeRule string
+
+const (
+	NO_ADJUSTMENT HighLatitudeRule = "none"
+	// Fajr and Ishaa are at most half the night away from sunrise/sunset.
+	MIDDLE_OF_NIGHT HighLatitudeRule = "middle_of_night"
+	// Fajr and Ishaa are at most a seventh of the night away from sunrise/sunset.
+	ONE_SEVENTH HighLatitudeRule = "one_seventh"
+	// Fajr and Ishaa are at most angle/60 of the night away from sunrise/sunset.
+	ANGLE_BASED HighLatitudeRule = "angle_based"
+)
+
+// CalculationParams configures the prayer times calculator.
+type CalculationParams struct {
+	Latitude  float64
+	Longitude float64
+	// Elevation above sea level in meters.
+	Elevation float64
+
+	// Method is the calculation convention. Defaults to Muslim World League.
+	Method CalcMethod
+
+	// AsrFactor is the shadow length factor of Asr: 1 for Shafi'i, Maliki and
+	// Hanbali, 2 for Hanafi. Defaults to 1.
+	AsrFactor int
+	// SecondAsr adds an Asr prayer following the other shadow factor.
+	SecondAsr bool
+
+	// HighLatRule adjusts Fajr and Ishaa in places where the twilight doesn't
+	// end in summer. Defaults to no adjustment.
+	HighLatRule HighLatitudeRule
+
+	// Timezone used to express the calculated prayer times. If nil, the
+	// location of the timestamp passed to GetTodayPrayerTimes is used.
+	Timezone *time.Location
+}
+
+// CalculatedPrayerTimes extends PrayerTimes to reuse GetNearestPrayers.
+type CalculatedPrayerTimes struct {
+	PrayerTimes
+
+	params CalculationParams
+}
+
+func NewCalculatedPrayerTimes(params CalculationParams, opts ...PrayerTimesOpt) (*CalculatedPrayerTimes, error) {
+	switch {
+	case params.Latitude < -90 || params.Latitude > 90:
+		return nil, fmt.Errorf("NewCalculatedPrayerTimes's latitude %v is out of range [-90, 90].", params.Latitude)
+	case params.Longitude < -180 || params.Longitude > 180:
+		return nil, fmt.Errorf("NewCalculatedPrayerTimes's longitude %v is out of range [-180, 180].", params.Longitude)
+	case params.Elevation < 0:
+		return nil, errors.New("NewCalculatedPrayerTimes's elevation should be non-negative.")
+	case params.HighLatRule != "" && params.HighLatRule != NO_ADJUSTMENT && params.HighLatRule != MIDDLE_OF_NIGHT &&
+		params.HighLatRule != ONE_SEVENTH && params.HighLatRule != ANGLE_BASED:
+		return nil, fmt.Errorf("NewCalculatedPrayerTimes's high latitude rule %q is unknown.", params.HighLatRule)
+	case params.AsrFactor != 0 && params.AsrFactor != 1 && params.AsrFactor != 2:
+		return nil, fmt.Errorf("NewCalculatedPrayerTimes's asr factor %d should be 1 (Shafi'i) or 2 (Hanafi).", params.AsrFactor)
+	}
+
+	if params.Method.fajrAngle == 0 {
+		params.Method = calcMethods[DEFAULT_METHOD]
+	}
+	if params.AsrFactor == 0 {
+		params.AsrFactor = 1
+	}
+
+	pt := &CalculatedPrayerTimes{params: params}
+	for _, opt := range opts {
+		opt(&pt.PrayerTimes)
+	}
+
+	now := clock.OrSystem(pt.clock).Now()
+	if err := pt.GetTodayPrayerTimes(context.Background(), now); err != nil {
+		return nil, fmt.Errorf("Error initializing NewCalculatedPrayerTimes for %s: %w", now.Format("2006-01-02"), err)
+	}
+	return pt, nil
+}
+
+// GetTodayPrayerTimes calculates the prayer times of the day of the input timestamp.
+func (p *CalculatedPrayerTimes) GetTodayPrayerTimes(ctx context.Context, now time.Time) error {
+	if p.params.Timezone != nil {
+		now = now.In(p.params.Timezone)
+	}
+	if !p.Date.IsZero() && p.Date == GetDate(now) {
+		return nil
+	}
+
+	if err := p.Load(ctx, now, p); err != nil {
+		return err
+	}
+
+	log.Printf("PrayerTimes today (method: %v): %v", p.params.Method, p.PrayerTimes)
+	return nil
+}
+
+// GetPrayerTimes calculates the prayer times of the day of a timestamp.
+func (p *CalculatedPrayerTimes) GetPrayerTimes(ctx context.Context, day time.Time) (*PrayerTimes, error) {
+	return p.adjust(p.PrayerTimesOn(ctx, day))
+}
+
+// PrayerTimesOn calculates the prayer times of the day of a timestamp.
+func (p *CalculatedPrayerTimes) PrayerTimesOn(ctx context.Context, day time.Time) (*PrayerTimes, error) {
+	if p.params.Timezone != nil {
+		day = day.In(p.params.Timezone)
+	}
+
+	times, err := p.calculate(day)
+	if err != nil {
+		return nil, fmt.Errorf("Error calculating prayer times for %s: %w", day.Format("2006-01-02"), err)
+	}
+
+	pt := &PrayerTimes{
+		Fajr:    &Prayer{Name: "Fajr", Time: times.fajr},
+		Sunrise: &Prayer{Name: "Sunrise", Time: times.sunrise},
+		Dhuhr:   &Prayer{Name: "Dhuhr", Time: times.dhuhr},
+		Asr:     &Prayer{Name: "Asr", Time: times.asr},
+		Maghrib: &Prayer{Name: "Maghrib", Time: times.maghrib},
+		Ishaa:   &Prayer{Name: "Ishaa", Time: times.isha},
+		Date:    GetDate(day),
+	}
+	if p.params.SecondAsr {
+		pt.SecondAsr = &Prayer{Name: asrName(secondAsrFactor(p.params.AsrFactor)), Time: times.secondAsr}
+	}
+
+	for _, pr := range pt.Prayers() {
+		// Maghrib and Ishaa may fall after midnight during short summer
+		// nights at high latitudes.
+		if pr == pt.Maghrib || pr == pt.Ishaa {
+			if !pr.Time.After(pt.Dhuhr.Time) {
+				return nil, fmt.Errorf("Found Inconsistency between Dhuhr at %v and the calculated %v at %v", pt.Dhuhr.Time, pr.Name, pr.Time)
+			}
+			continue
+		}
+		if !isSameDay(day, pr.Time) {
+			return nil, fmt.Errorf("Found Inconsistency of dates between %v and the calculated %v at %v", day.Format(DATE_LAYOUT), pr.Name, pr.Time)
+		}
+	}
+	return pt, nil
+}
+
+// solarTimes holds the calculated times of a single day.
+type solarTimes struct {
+	fajr    time.Time
+	sunrise time.Time
+	dhuhr   time.Time
+	asr     time.Time
+	// secondAsr follows the other Asr shadow factor.
+	secondAsr time.Time
+	sunset    time.Time
+	maghrib   time.Time
+	isha      time.Time
+}
+
+// calculate computes the prayer times of the day of the input timestamp,
+// expressed in the timestamp's location. It fails if the sun does not reach one
+// of the required angles and no high latitude rule can replace it.
+func (p *CalculatedPrayerTimes) calculate(day time.Time) (solarTimes, error) {
+	c := &solarCalculator{
+		lat: p.params.Latitude,
+		jd:  julianDate(day.Year(), day.Month(), day.Day()) - p.params.Longitude/(15*24),
+	}
+
+	// Initial guesses (in hours) refined by one iteration, as the sun's
+	// position depends on the time of the day.
+	riseSet := riseSetAngle(p.params.Elevation)
+	method := p.params.Method
+	guess := solarHours{fajr: 5, sunrise: 6, dhuhr: 12, asr: 13, secondAsr: 13, sunset: 18, maghrib: 18, isha: 18}
+	h := c.solarHours(p.params, riseSet, guess)
+	h = c.solarHours(p.params, riseSet, h)
+
+	if method.maghribAngle == 0 {
+		h.maghrib = h.sunset
+	}
+	adjusted := p.params.HighLatRule != "" && p.params.HighLatRule != NO_ADJUSTMENT
+	if adjusted {
+		h.adjustHighLatitudes(method, p.params.HighLatRule)
+	}
+	if method.ishaInterval > 0 {
+		h.isha = h.maghrib + method.ishaInterval.Hours()
+	}
+
+	for _, t := range []struct {
+		name  string
+		hours float64
+		angle float64
+	}{
+		// Sunrise and sunset come first, as the adjusted times derive from them.
+		{"Sunrise", h.sunrise, riseSet},
+		{"Sunset", h.sunset, riseSet},
+		{"Fajr", h.fajr, method.fajrAngle},
+		{"Maghrib", h.maghrib, method.maghribAngle},
+		{"Ishaa", h.isha, method.ishaAngle},
+	} {
+		if math.IsNaN(t.hours) && adjusted {
+			// Polar night or midnight sun: no rule can replace sunrise or sunset.
+			return solarTimes{}, fmt.Errorf("%s does not occur on %s at latitude %v: the sun does not cross %v° below the horizon.", t.name, day.Format("2006-01-02"), p.params.Latitude, t.angle)
+		}
+		if math.IsNaN(t.hours) {
+			return solarTimes{}, fmt.Errorf("%s has no solution at latitude %v: the sun does not reach %v° below the horizon. Use a high latitude rule.", t.name, p.params.Latitude, t.angle)
+		}
+	}
+
+	toTime := func(hours float64) time.Time {
+		// Calculated hours are relative to the local solar time of the longitude.
+		utc := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.UTC)
+		d := time.Duration((hours - p.params.Longitude/15) * float64(time.Hour))
+		return utc.Add(d).Round(time.Minute).In(day.Location())
+	}
+
+	return solarTimes{
+		fajr:      toTime(h.fajr),
+		sunrise:   toTime(h.sunrise),
+		dhuhr:     toTime(h.dhuhr),
+		asr:       toTime(h.asr),
+		secondAsr: toTime(h.secondAsr),
+		sunset:    toTime(h.sunset),
+		maghrib:   toTime(h.maghrib),
+		isha:      toTime(h.isha),
+	}, nil
+}
+
+// solarHours holds the calculated times of a single day in hours.
+type solarHours struct {
+	fajr    float64
+	sunrise float64
+	dhuhr   float64
+	asr     float64
+	// secondAsr follows the other Asr shadow factor.
+	secondAsr float64
+	sunset    float64
+	maghrib   float64
+	isha      float64
+}
+
+// solarHours computes the times of a day, evaluating the sun's position at the
+// times of the previous estimate t.
+func (c *solarCalculator) solarHours(params CalculationParams, riseSet float64, t solarHours) solarHours {
+	portion := func(h float64) float64 { return h / 24 }
+	method := params.Method
+	return solarHours{
+		fajr:      c.sunAngleTime(method.fajrAngle, portion(t.fajr), true),
+		sunrise:   c.sunAngleTime(riseSet, portion(t.sunrise), true),
+		dhuhr:     c.midDay(portion(t.dhuhr)),
+		asr:       c.asrTime(float64(params.AsrFactor), portion(t.asr)),
+		secondAsr: c.asrTime(float64(secondAsrFactor(params.AsrFactor)), portion(t.secondAsr)),
+		sunset:    c.sunAngleTime(riseSet, portion(t.sunset), false),
+		maghrib:   c.sunAngleTime(method.maghribAngle, portion(t.maghrib), false),
+		isha:      c.sunAngleTime(method.ishaAngle, portion(t.isha), false),
+	}
+}
+
+// adjustHighLatitudes replaces Fajr, Maghrib and Ishaa if they don't exist or
+// are too far from sunrise/sunset, in favor of a portion of the night.
+func (h *solarHours) adjustHighLatitudes(method CalcMethod, rule HighLatitudeRule) {
+	night := fixHour(h.sunrise - h.sunset)
+
+	h.fajr = adjustHighLatitudeTime(h.fajr, h.sunrise, method.fajrAngle, night, rule, true)
+	if method.maghribAngle > 0 {
+		h.maghrib = adjustHighLatitudeTime(h.maghrib, h.sunset, method.maghribAngle, night, rule, false)
+	}
+	if method.ishaInterval == 0 {
+		h.isha = adjustHighLatitudeTime(h.isha, h.sunset, method.ishaAngle, night, rule, false)
+	}
+}
+
+// adjustHighLatitudeTime bounds the time t to a portion of the night before
+// (ccw) or after base.
+func adjustHighLatitudeTime(t, base, angle, night float64, rule HighLatitudeRule, ccw bool) float64 {
+	var portion float64
+	switch rule {
+	case ANGLE_BASED:
+		portion = angle / 60 * night
+	case ONE_SEVENTH:
+		portion = night / 7
+	default:
+		portion = night / 2
+	}
+
+	diff := fixHour(t - base)
+	if ccw {
+		diff = fixHour(base - t)
+	}
+	if !math.IsNaN(t) && diff <= portion {
+		return t
+	}
+	if ccw {
+		return base - portion
+	}
+	return base + portion
+}
+
+// secondAsrFactor returns the shadow factor of the other juristic convention.
+func secondAsrFactor(factor int) int {
+	if factor == 2 {
+		return 1
+	}
+	return 2
+}
+
+// asrName returns the name of the Asr prayer of a shadow factor.
+func asrName(factor int) string {
+	if factor == 2 {
+		return "Asr (Hanafi)"
+	}
+	return "Asr (Shafi'i)"
+}
+
+// solarCalculator evaluates the sun's position for a given julian date and
+// observer's coordinates. All angles are in degrees and all times in hours.
+type solarCalculator struct {
+	lat float64
+	jd  float64
+}
+
+// sunPosition returns the declination of the sun and the equation of time at
+// julian date jd.
+func sunPosition(jd float64) (declination, equation float64) {
+	d := jd - 2451545.0
+	g := fixAngle(357.529 + 0.98560028*d)
+	q := fixAngle(280.459 + 0.98564736*d)
+	l := fixAngle(q + 1.915*dsin(g) + 0.020*dsin(2*g))
+	e := 23.439 - 0.00000036*d
+
+	ra := darctan2(dcos(e)*dsin(l), dcos(l)) / 15
+	equation = q/15 - fixHour(ra)
+	declination = darcsin(dsin(e) * dsin(l))
+	return declination, equation
+}
+
+// midDay returns the time of the solar noon.
+func (c *solarCalculator) midDay(t float64) float64 {
+	_, eqt := sunPosition(c.jd + t)
+	return fixHour(12 - eqt)
+}
+
+// sunAngleTime returns the time at which the sun reaches a depression angle
+// below the horizon. ccw is true for times before noon.
+func (c *solarCalculator) sunAngleTime(angle, t float64, ccw bool) float64 {
+	decl, _ := sunPosition(c.jd + t)
+	noon := c.midDay(t)
+	ha := darccos((-dsin(angle)-dsin(decl)*dsin(c.lat))/(dcos(decl)*dcos(c.lat))) / 15
+	if ccw {
+		return noon - ha
+	}
+	return noon + ha
+}
+
+// asrTime returns the time at which an object's shadow equals factor times
+// its length plus the length of its shadow at noon.
+func (c *solarCalculator) asrTime(factor, t float64) float64 {
+	decl, _ := sunPosition(c.jd + t)
+	angle := -darccot(factor + dtan(math.Abs(c.lat-decl)))
+	return c.sunAngleTime(angle, t, false)
+}
+
+// riseSetAngle returns the sun's depression angle at sunrise and sunset,
+// corrected for the observer's elevation in meters.
+func riseSetAngle(elevation float64) float64 {
+	return 0.833 + 0.0347*math.Sqrt(elevation)
+}
+
+// julianDate returns the julian date at midnight UTC of a gregorian date.
+func julianDate(year int, month time.Month, day int) float64 {
+	y, m := float64(year), float64(month)
+	if m <= 2 {
+		y -= 1
+		m += 12
+	}
+	a := math.Floor(y / 100)
+	b := 2 - a + math.Floor(a/4)
+	return math.Floor(365.25*(y+4716)) + math.Floor(30.6001*(m+1)) + float64(day) + b - 1524.5
+}
+
+// Degree based trigonometric helpers.
+
+func dsin(d float64) float64    { return math.Sin(d * math.Pi / 180) }
+func dcos(d float64) float64    { return math.Cos(d * math.Pi / 180) }
+func dtan(d float64) float64    { return math.Tan(d * math.Pi / 180) }
+func darcsin(x float64) float64 { return math.Asin(x) * 180 / math.Pi }
+func darccos(x float64) float64 { return math.Acos(x) * 180 / math.Pi }
+func darccot(x float64) float64 { return math.Atan(1/x) * 180 / math.Pi }
+func darctan2(y, x float64) float64 {
+	return math.Atan2(y, x) * 180 / math.Pi
+}
+
+func fixAngle(a float64) float64 { return fix(a, 360) }
+func fixHour(h float64) float64  { return fix(h, 24) }
+
+func fix(a, b float64) float64 {
+	a = a - b*math.Floor(a/b)
+	if a < 0 {
+		return a + b
+	}
+	return a
+}
@@ -0,0 +1,303 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prayertimes
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+var munichParams = CalculationParams{
+	Latitude:  48.1374,
+	Longitude: 11.5755,
+	Elevation: 520,
+	Method:    calcMethods["mwl"],
+	AsrFactor: 1,
+}
+
+func TestCalculatedPrayerTimesMatchMunichTable(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatalf("Failed to load location: %v", err)
+	}
+
+	// The Munich timetable adds 5 minutes to Dhuhr (after zawal).
+	const dhuhrMargin = 5 * time.Minute
+	const tolerance = 3 * time.Minute
+
+	for _, day := range []time.Time{
+		time.Date(2023, time.January, 10, 12, 0, 0, 0, berlin),
+		time.Date(2023, time.March, 28, 12, 0, 0, 0, berlin),
+		time.Date(2023, time.October, 10, 12, 0, 0, 0, berlin),
+		time.Date(2023, time.December, 20, 12, 0, 0, 0, berlin),
+	} {
+		t.Run(day.Format("2006-01-02"), func(t *testing.T) {
+			p := &CalculatedPrayerTimes{params: munichParams}
+			if err := p.GetTodayPrayerTimes(context.Background(), day); err != nil {
+				t.Fatalf("GetTodayPrayerTimes returned error, expected None: %v", err)
+			}
+
+			row := munich2023[day.Month()-1][6*(day.Day()-1):]
+			for _, test := range []struct {
+				got    *Prayer
+				column int
+				margin time.Duration
+			}{
+				{got: p.Fajr, column: 0},
+				{got: p.Dhuhr, column: 2, margin: dhuhrMargin},
+				{got: p.Asr, column: 3},
+				{got: p.Maghrib, column: 4},
+				{got: p.Ishaa, column: 5},
+			} {
+				parsed, err := time.Parse("15:04", row[test.column])
+				if err != nil {
+					t.Fatalf("Failed to parse the time %v: %v", row[test.column], err)
+				}
+				want := time.Date(day.Year(), day.Month(), day.Day(), parsed.Hour(), parsed.Minute(), 0, 0, berlin)
+
+				if diff := want.Sub(test.got.Time) - test.margin; diff > tolerance || diff < -tolerance {
+					t.Errorf("%v mismatch. Got %v, want %v (±%v)", test.got.Name, test.got.Time.Format("15:04"), want.Add(-test.margin).Format("15:04"), tolerance)
+				}
+			}
+		})
+	}
+}
+
+func TestCalculatedPrayerTimesUsesTimezone(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatalf("Failed to load location: %v", err)
+	}
+
+	params := munichParams
+	params.Timezone = berlin
+	p := &CalculatedPrayerTimes{params: params}
+
+	// 23:30 UTC is already the next day in Munich.
+	if err := p.GetTodayPrayerTimes(context.Background(), time.Date(2023, time.January, 9, 23, 30, 0, 0, time.UTC)); err != nil {
+		t.Fatalf("GetTodayPrayerTimes returned error, expected None: %v", err)
+	}
+	if want := time.Date(2023, time.January, 10, 0, 0, 0, 0, berlin); !p.Date.Equal(want) {
+		t.Errorf("Prayer times date mismatch. Got %v, want %v", p.Date, want)
+	}
+	if loc := p.Fajr.Time.Location(); loc != berlin {
+		t.Errorf("Prayer times location mismatch. Got %v, want %v", loc, berlin)
+	}
+}
+
+func TestCalculatedPrayerTimesDaylightSavingTime(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatalf("Failed to load location: %v", err)
+	}
+	params := munichParams
+	params.Timezone = berlin
+
+	for _, test := range []struct {
+		description string
+		before      time.Time
+		after       time.Time
+		wantShift   time.Duration
+	}{
+		{
+			description: "Spring forward",
+			before:      time.Date(2023, time.March, 25, 12, 0, 0, 0, berlin),
+			after:       time.Date(2023, time.March, 26, 12, 0, 0, 0, berlin),
+			wantShift:   time.Hour,
+		},
+		{
+			description: "Fall back",
+			before:      time.Date(2023, time.October, 28, 12, 0, 0, 0, berlin),
+			after:       time.Date(2023, time.October, 29, 12, 0, 0, 0, berlin),
+			wantShift:   -time.Hour,
+		},
+	} {
+		t.Run(test.description, func(t *testing.T) {
+			p := &CalculatedPrayerTimes{params: params}
+			before, err := p.GetPrayerTimes(context.Background(), test.before)
+			if err != nil {
+				t.Fatalf("GetPrayerTimes returned error, expected None: %v", err)
+			}
+			after, err := p.GetPrayerTimes(context.Background(), test.after)
+			if err != nil {
+				t.Fatalf("GetPrayerTimes returned error, expected None: %v", err)
+			}
+
+			// Prayers move by minutes in absolute time but by an hour on the clock.
+			for i, pb := range before.Prayers() {
+				pa := after.Prayers()[i]
+				if diff := pa.Time.Sub(pb.Time) - 24*time.Hour; diff > 5*time.Minute || diff < -5*time.Minute {
+					t.Errorf("%v moved by %v in absolute time, want about 24h", pa.Name, pa.Time.Sub(pb.Time))
+				}
+				clock := func(ts time.Time) time.Duration {
+					return time.Duration(ts.Hour())*time.Hour + time.Duration(ts.Minute())*time.Minute
+				}
+				if diff := clock(pa.Time) - clock(pb.Time) - test.wantShift; diff > 5*time.Minute || diff < -5*time.Minute {
+					t.Errorf("%v moved from %v to %v on the clock, want a shift of about %v", pa.Name, pb.Time.Format(TIME_LAYOUT), pa.Time.Format(TIME_LAYOUT), test.wantShift)
+				}
+			}
+		})
+	}
+}
+
+func TestCalculatedAsrFactors(t *testing.T) {
+	day := time.Date(2023, time.January, 10, 12, 0, 0, 0, time.UTC)
+
+	shafii := munichParams
+	shafii.SecondAsr = true
+	hanafi := shafii
+	hanafi.AsrFactor = 2
+
+	ps := &CalculatedPrayerTimes{params: shafii}
+	if err := ps.GetTodayPrayerTimes(context.Background(), day); err != nil {
+		t.Fatalf("GetTodayPrayerTimes returned error, expected None: %v", err)
+	}
+	ph := &CalculatedPrayerTimes{params: hanafi}
+	if err := ph.GetTodayPrayerTimes(context.Background(), day); err != nil {
+		t.Fatalf("GetTodayPrayerTimes returned error, expected None: %v", err)
+	}
+
+	if !ph.Asr.Time.After(ps.Asr.Time) {
+		t.Errorf("Hanafi Asr %v should be after Shafi'i Asr %v", ph.Asr.Time, ps.Asr.Time)
+	}
+	if !ps.SecondAsr.Time.Equal(ph.Asr.Time) || ps.SecondAsr.Name != "Asr (Hanafi)" {
+		t.Errorf("Second Asr mismatch. Got %v at %v, want Asr (Hanafi) at %v", ps.SecondAsr.Name, ps.SecondAsr.Time, ph.Asr.Time)
+	}
+	if !ph.SecondAsr.Time.Equal(ps.Asr.Time) || ph.SecondAsr.Name != "Asr (Shafi'i)" {
+		t.Errorf("Second Asr mismatch. Got %v at %v, want Asr (Shafi'i) at %v", ph.SecondAsr.Name, ph.SecondAsr.Time, ps.Asr.Time)
+	}
+}
+
+func TestCalculatedHighLatitudeRules(t *testing.T) {
+	oslo := CalculationParams{Latitude: 59.9139, Longitude: 10.7522, Method: calcMethods["mwl"], AsrFactor: 1}
+	midsummer := time.Date(2023, time.June, 21, 12, 0, 0, 0, time.UTC)
+
+	t.Run("No adjustment has no solution", func(t *testing.T) {
+		if _, err := (&CalculatedPrayerTimes{params: oslo}).calculate(midsummer); err == nil {
+			t.Errorf("calculate should fail when twilight doesn't end. Got none.")
+		}
+	})
+
+	for _, test := range []struct {
+		rule HighLatitudeRule
+		// portion of the night between sunset and Ishaa.
+		wantPortion float64
+	}{
+		{rule: MIDDLE_OF_NIGHT, wantPortion: 1.0 / 2},
+		{rule: ONE_SEVENTH, wantPortion: 1.0 / 7},
+		{rule: ANGLE_BASED, wantPortion: 17.0 / 60},
+	} {
+		t.Run(string(test.rule), func(t *testing.T) {
+			params := oslo
+			params.HighLatRule = test.rule
+			got, err := (&CalculatedPrayerTimes{params: params}).calculate(midsummer)
+			if err != nil {
+				t.Fatalf("calculate returned error, expected None: %v", err)
+			}
+
+			night := got.sunrise.Add(24 * time.Hour).Sub(got.sunset)
+			want := got.sunset.Add(time.Duration(test.wantPortion * float64(night))).Round(time.Minute)
+			if diff := got.isha.Sub(want); diff > time.Minute || diff < -time.Minute {
+				t.Errorf("Ishaa mismatch. Got %v, want %v", got.isha, want)
+			}
+			if !got.fajr.Before(got.sunrise) {
+				t.Errorf("Fajr %v should be before sunrise %v", got.fajr, got.sunrise)
+			}
+		})
+	}
+
+	t.Run("Rule can't replace sunrise in polar night", func(t *testing.T) {
+		tromso := CalculationParams{Latitude: 69.6492, Longitude: 18.9553, Method: calcMethods["mwl"], AsrFactor: 1, HighLatRule: ANGLE_BASED}
+		polarNight := time.Date(2023, time.December, 21, 12, 0, 0, 0, time.UTC)
+		_, err := (&CalculatedPrayerTimes{params: tromso}).calculate(polarNight)
+		if err == nil {
+			t.Fatalf("calculate should fail when the sun doesn't rise. Got none.")
+		}
+		if want := "Sunrise does not occur on 2023-12-21 at latitude 69.6492"; !strings.Contains(err.Error(), want) {
+			t.Errorf("Error mismatch. Got %q, want it to contain %q", err, want)
+		}
+		if strings.Contains(err.Error(), "Use a high latitude rule") {
+			t.Errorf("Error %q suggests a high latitude rule although one is set", err)
+		}
+	})
+
+	t.Run("Maghrib after midnight around the solstice", func(t *testing.T) {
+		reykjavik := CalculationParams{Latitude: 64.1466, Longitude: -21.9426, Method: calcMethods["mwl"], AsrFactor: 1, HighLatRule: ANGLE_BASED, Timezone: time.UTC}
+		p := &CalculatedPrayerTimes{params: reykjavik}
+		got, err := p.PrayerTimesOn(context.Background(), time.Date(2024, time.June, 15, 12, 0, 0, 0, time.UTC))
+		if err != nil {
+			t.Fatalf("PrayerTimesOn returned error, expected None: %v", err)
+		}
+		if y, m, d := got.Maghrib.Time.Date(); y != 2024 || m != time.June || d != 16 {
+			t.Errorf("Maghrib %v should fall after midnight", got.Maghrib.Time)
+		}
+		if !got.Ishaa.Time.After(got.Maghrib.Time) {
+			t.Errorf("Ishaa %v should be after Maghrib %v", got.Ishaa.Time, got.Maghrib.Time)
+		}
+	})
+
+	t.Run("Rule doesn't change times in winter", func(t *testing.T) {
+		winter := time.Date(2023, time.January, 10, 12, 0, 0, 0, time.UTC)
+		params := oslo
+		want, err := (&CalculatedPrayerTimes{params: params}).calculate(winter)
+		if err != nil {
+			t.Fatalf("calculate returned error, expected None: %v", err)
+		}
+		params.HighLatRule = ANGLE_BASED
+		got, err := (&CalculatedPrayerTimes{params: params}).calculate(winter)
+		if err != nil {
+			t.Fatalf("calculate returned error, expected None: %v", err)
+		}
+		if got != want {
+			t.Errorf("Winter times mismatch. Got %v, want %v", got, want)
+		}
+	})
+}
+
+func TestInvalidNewCalculatedPrayerTimes(t *testing.T) {
+	for _, test := range []struct {
+		description string
+		params      CalculationParams
+	}{
+		{
+			description: "Latitude out of range",
+			params:      CalculationParams{Latitude: 91},
+		},
+		{
+			description: "Longitude out of range",
+			params:      CalculationParams{Longitude: -181},
+		},
+		{
+			description: "Negative elevation",
+			params:      CalculationParams{Elevation: -1},
+		},
+		{
+			description: "Unknown high latitude rule",
+			params:      CalculationParams{HighLatRule: "unknown"},
+		},
+		{
+			description: "Unknown Asr factor",
+			params:      CalculationParams{AsrFactor: 3},
+		},
+	} {
+		t.Run(test.description, func(t *testing.T) {
+			if _, err := NewCalculatedPrayerTimes(test.params); err == nil {
+				t.Errorf("NewCalculatedPrayerTimes should raise an input validation error. Got none.")
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Hijri calendar. Converts Gregorian dates to the tabular (arithmetical)
+// Islamic calendar with 11 leap years per 30 year cycle. It often agrees with
+// Umm al-Qura, but local moon sighting may differ by a day or two, which is
+// corrected by an adjustment in days.
+
+package prayertimes
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// HIJRI_EPOCH is the Julian day number of 1 Muharram 1 AH (16 July 622
+	// in the Julian calendar).
+	HIJRI_EPOCH = 1948440
+
+	// MAX_HIJRI_ADJUSTMENT is the largest supported moon sighting adjustment
+	// in days.
+	MAX_HIJRI_ADJUSTMENT = 2
+)
+
+var hijriMonths = []string{
+	"Muharram", "Safar", "Rabi' al-Awwal", "Rabi' al-Thani", "Jumada al-Ula", "Jumada al-Akhirah",
+	"Rajab", "Sha'ban", "Ramadan", "Shawwal", "Dhu al-Qi'dah", "Dhu al-Hijjah",
+}
+
+// HijriDate is a date of the Islamic calendar. Months start at 1 (Muharram).
+type HijriDate struct {
+	year  int
+	month int
+	day   int
+}
+
+// String returns the date e.g. "1 Ramadan 1445 AH".
+func (h HijriDate) String() string {
+	if h.month < 1 || h.month > len(hijriMonths) {
+		return fmt.Sprintf("%d-%02d-%02d AH", h.year, h.month, h.day)
+	}
+	return fmt.Sprintf("%d %s %d AH", h.day, hijriMonths[h.month-1], h.year)
+}
+
+// Year returns the year of the date e.g. 1445.
+func (h HijriDate) Year() int {
+	return h.year
+}
+
+// Month returns the month of the date e.g. 9 for Ramadan.
+func (h HijriDate) Month() int {
+	return h.month
+}
+
+// Day returns the day of the month of the date, from 1 to 30.
+func (h HijriDate) Day() int {
+	return h.day
+}
+
+// ToHijri converts the civil date of a timestamp to the tabular Hijri date,
+// shifted by adjustment days e.g. -1 if the month started a day later locally.
+// Like PrayerTimes.date, the date changes at midnight and not at Maghrib.
+func ToHijri(t time.Time, adjustment int) HijriDate {
+	// Integer arithmetic of the tabular calendar by the 30 year cycle of
+	// 10631 days.
+	l := julianDayNumber(t) + adjustment - HIJRI_EPOCH + 10632
+	n := (l - 1) / 10631
+	l = l - 10631*n + 354
+	j := ((10985-l)/5316)*((50*l)/17719) + (l/5670)*((43*l)/15238)
+	l = l - ((30-j)/15)*((17719*j)/50) - (j/16)*((15238*j)/43) + 29
+	m := (24 * l) / 709
+	return HijriDate{
+		year:  30*n + j - 30,
+		month: m,
+		day:   l - (709*m)/24,
+	}
+}
+
+// julianDayNumber returns the Julian day number of the Gregorian date of t.
+func julianDayNumber(t time.Time) int {
+	y, month, d := t.Date()
+	a := (14 - int(month)) / 12
+	y = y + 4800 - a
+	m := int(month) + 12*a - 3
+	return d + (153*m+2)/5 + 365*y + y/4 - y/100 + y/400 - 32045
+}
@@ -0,0 +1,135 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prayertimes
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestToHijri(t *testing.T) {
+	for _, test := range []struct {
+		description string
+		date        time.Time
+		adjustment  int
+
+		want HijriDate
+	}{
+		{
+			description: "Epoch",
+			date:        time.Date(622, time.July, 19, 0, 0, 0, 0, time.UTC),
+			want:        HijriDate{year: 1, month: 1, day: 1},
+		},
+		{
+			description: "Islamic new year 1445",
+			date:        time.Date(2023, time.July, 19, 0, 0, 0, 0, time.UTC),
+			want:        HijriDate{year: 1445, month: 1, day: 1},
+		},
+		{
+			description: "First day of Ramadan 1445",
+			date:        time.Date(2024, time.March, 11, 0, 0, 0, 0, time.UTC),
+			want:        HijriDate{year: 1445, month: 9, day: 1},
+		},
+		{
+			description: "Last day of Sha'ban 1445",
+			date:        time.Date(2024, time.March, 10, 23, 59, 0, 0, time.UTC),
+			want:        HijriDate{year: 1445, month: 8, day: 29},
+		},
+		{
+			description: "Eid al-Fitr 1445",
+			date:        time.Date(2024, time.April, 10, 0, 0, 0, 0, time.UTC),
+			want:        HijriDate{year: 1445, month: 10, day: 1},
+		},
+		{
+			description: "Moon sighted a day later",
+			date:        time.Date(2024, time.March, 11, 0, 0, 0, 0, time.UTC),
+			adjustment:  -1,
+			want:        HijriDate{year: 1445, month: 8, day: 29},
+		},
+		{
+			description: "Moon sighted a day earlier",
+			date:        time.Date(2024, time.March, 10, 0, 0, 0, 0, time.UTC),
+			adjustment:  1,
+			want:        HijriDate{year: 1445, month: 9, day: 1},
+		},
+		{
+			description: "30th of Dhu al-Hijjah in a leap year",
+			date:        time.Date(2024, time.July, 7, 0, 0, 0, 0, time.UTC),
+			want:        HijriDate{year: 1445, month: 12, day: 30},
+		},
+	} {
+		t.Run(test.description, func(t *testing.T) {
+			if got := ToHijri(test.date, test.adjustment); got != test.want {
+				t.Errorf("ToHijri(%v) mismatch. Got %v, want %v", test.date, got, test.want)
+			}
+		})
+	}
+}
+
+func TestToHijriUsesTheLocalDate(t *testing.T) {
+	tokyo := time.FixedZone("JST", 9*60*60)
+	// 2024-03-10 20:00 UTC is already 2024-03-11 in Tokyo.
+	utc := time.Date(2024, time.March, 10, 20, 0, 0, 0, time.UTC)
+
+	if got, want := ToHijri(utc.In(tokyo), 0), (HijriDate{year: 1445, month: 9, day: 1}); got != want {
+		t.Errorf("ToHijri mismatch. Got %v, want %v", got, want)
+	}
+}
+
+func TestHijriDateString(t *testing.T) {
+	if got, want := (HijriDate{year: 1445, month: 9, day: 1}).String(), "1 Ramadan 1445 AH"; got != want {
+		t.Errorf("String mismatch. Got %q, want %q", got, want)
+	}
+}
+
+func TestHijriDateAccessors(t *testing.T) {
+	h := ToHijri(time.Date(2024, time.April, 10, 0, 0, 0, 0, time.UTC), 0)
+	if got, want := []int{h.Year(), h.Month(), h.Day()}, []int{1445, 10, 1}; !cmp.Equal(got, want) {
+		t.Errorf("Year, Month and Day mismatch. Got %v, want %v", got, want)
+	}
+}
+
+func TestPrayerTimesHijriDate(t *testing.T) {
+	days, err := newTimetableDays(munichTimetable(2023, munich2023))
+	if err != nil {
+		t.Fatalf("newTimetableDays returned error, expected None: %v", err)
+	}
+	p := &MunichPrayerTimes{TimetablePrayerTimes{days: days}}
+	HijriAdjustment(1)(&p.PrayerTimes)
+
+	// 2023-03-22 is 29 Sha'ban 1444, i.e. 1 Ramadan shifted by a day.
+	if err := p.GetTodayPrayerTimes(context.Background(), time.Date(2023, time.March, 22, 10, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatalf("GetTodayPrayerTimes returned error, expected None: %v", err)
+	}
+
+	got := []HijriDate{}
+	for _, day := range p.GetDays() {
+		got = append(got, day.hijriDate)
+	}
+	want := []HijriDate{
+		{year: 1444, month: 8, day: 29},
+		{year: 1444, month: 9, day: 1},
+		{year: 1444, month: 9, day: 2},
+	}
+	if diff := cmp.Diff(want, got, cmp.AllowUnexported(HijriDate{})); diff != "" {
+		t.Errorf("Hijri dates mismatch (-want +got):\n%s", diff)
+	}
+	if p.GetHijriDate() != want[1] {
+		t.Errorf("GetHijriDate mismatch. Got %v, want %v", p.GetHijriDate(), want[1])
+	}
+}
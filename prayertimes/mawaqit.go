@@ -0,0 +1,246 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Mosque prayer times. Reads the prayer and iqama times a mosque publishes in
+// the Mawaqit (https://mawaqit.net) JSON format from a file or an HTTP URL:
+//
+//	{
+//	  "name": "Munich Mosque",
+//	  "calendar": [{"1": ["06:10", "07:59", "12:22", "14:15", "16:35", "18:17"], ...}, ...],
+//	  "iqamaCalendar": [{"1": ["+20", "+10", "+10", "+5", "19:30"], ...}, ...]
+//	}
+//
+// calendar has a month per entry, mapping the days to the times of Fajr,
+// Shuruq (sunrise), Dhuhr, Asr, Maghrib and Ishaa. The optional iqamaCalendar
+// maps the days to the iqama of the 5 prayers, either in minutes after the
+// Adhan e.g. "+10" or at a time of day e.g. "19:30". Both repeat every year.
+
+package prayertimes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ssafty/adhan-homeassistant-pi/clock"
+	"github.com/ssafty/adhan-homeassistant-pi/httpclient"
+)
+
+const MAWAQIT_TIMEOUT = 30 * time.Second
+
+// mawaqitResponse is the subset of a mosque's Mawaqit JSON used.
+type mawaqitResponse struct {
+	Name string `json:"name"`
+	// Calendar and IqamaCalendar have 12 months mapping the day of the
+	// month e.g. "31" to its times.
+	Calendar      []map[string][]string `json:"calendar"`
+	IqamaCalendar []map[string][]string `json:"iqamaCalendar"`
+}
+
+// MawaqitPrayerTimes extends PrayerTimes to reuse GetNearestPrayers.
+type MawaqitPrayerTimes struct {
+	PrayerTimes
+
+	// source is the file path or URL of the mosque's times.
+	source string
+	mosque mawaqitResponse
+}
+
+// NewMawaqitPrayerTimes reads a mosque's times from a file path or an
+// http(s):// URL. ctx bounds the request e.g. to stop on SIGTERM.
+func NewMawaqitPrayerTimes(ctx context.Context, source string, opts ...PrayerTimesOpt) (*MawaqitPrayerTimes, error) {
+	if source == "" {
+		return nil, errors.New("NewMawaqitPrayerTimes's source is not specified.")
+	}
+
+	body, err := readMawaqit(ctx, source)
+	if err != nil {
+		return nil, fmt.Errorf("NewMawaqitPrayerTimes reading %s failed: %w", source, err)
+	}
+	mosque, err := parseMawaqit(body, source)
+	if err != nil {
+		return nil, fmt.Errorf("NewMawaqitPrayerTimes failed: %w", err)
+	}
+
+	pt := &MawaqitPrayerTimes{source: source, mosque: mosque}
+	for _, opt := range opts {
+		opt(&pt.PrayerTimes)
+	}
+
+	now := clock.OrSystem(pt.clock).Now()
+	if err := pt.GetTodayPrayerTimes(ctx, now); err != nil {
+		return nil, fmt.Errorf("Error initializing NewMawaqitPrayerTimes for %s: %w", now.Format(DATE_LAYOUT), err)
+	}
+	return pt, nil
+}
+
+// readMawaqit reads the body of a file path or an http(s):// URL.
+func readMawaqit(ctx context.Context, source string) ([]byte, error) {
+	if !strings.HasPrefix(source, "http://") && !strings.HasPrefix(source, "https://") {
+		return os.ReadFile(source)
+	}
+
+	client := httpclient.NewHTTPClient("", httpclient.Client(&http.Client{Timeout: MAWAQIT_TIMEOUT}), httpclient.Retries(httpclient.Backoff{}))
+	resp, statusCode, err := client.Get(ctx, source)
+	if err != nil {
+		return nil, fmt.Errorf("encountered error from Get(%s) request: %w", source, err)
+	}
+	if statusCode != 200 {
+		return nil, fmt.Errorf("unsuccessful response status code. Received statusCode: %d for Get(%s): %v", statusCode, source, resp)
+	}
+	return []byte(resp), nil
+}
+
+// parseMawaqit decodes a mosque's times and checks the number of months and
+// times per day.
+func parseMawaqit(body []byte, name string) (mawaqitResponse, error) {
+	mosque := mawaqitResponse{}
+	if err := json.Unmarshal(body, &mosque); err != nil {
+		return mawaqitResponse{}, fmt.Errorf("%s: error decoding JSON: %w", name, err)
+	}
+
+	for _, c := range []struct {
+		name     string
+		months   []map[string][]string
+		times    int
+		optional bool
+	}{
+		{"calendar", mosque.Calendar, len(timetableColumns), false},
+		{"iqamaCalendar", mosque.IqamaCalendar, 5, true},
+	} {
+		if len(c.months) == 0 && c.optional {
+			continue
+		}
+		if len(c.months) != 12 {
+			return mawaqitResponse{}, fmt.Errorf("%s: %s has %d months, expected 12", name, c.name, len(c.months))
+		}
+		for i, month := range c.months {
+			for day, times := range month {
+				if len(times) != c.times {
+					return mawaqitResponse{}, fmt.Errorf("%s: %s[%d][%q] has %d times, expected %d", name, c.name, i, day, len(times), c.times)
+				}
+			}
+		}
+	}
+	return mosque, nil
+}
+
+// GetTodayPrayerTimes reads today's prayer times from the mosque's calendar.
+func (p *MawaqitPrayerTimes) GetTodayPrayerTimes(ctx context.Context, now time.Time) error {
+	now = p.in(now)
+	if !p.Date.IsZero() && p.Date == GetDate(now) {
+		return nil
+	}
+
+	if err := p.Load(ctx, now, p); err != nil {
+		return err
+	}
+
+	log.Printf("PrayerTimes today (%v): %v", p.mosque.Name, p.PrayerTimes)
+	return nil
+}
+
+// GetPrayerTimes reads the prayer times of the day of a timestamp.
+func (p *MawaqitPrayerTimes) GetPrayerTimes(ctx context.Context, day time.Time) (*PrayerTimes, error) {
+	return p.adjust(p.PrayerTimesOn(ctx, day))
+}
+
+// PrayerTimesOn reads the prayer and iqama times of a day from the mosque's
+// calendar. Days that are not covered fail, unless a fallback is configured.
+func (p *MawaqitPrayerTimes) PrayerTimesOn(ctx context.Context, day time.Time) (*PrayerTimes, error) {
+	day = p.in(day)
+	times, ok := p.mosque.Calendar[day.Month()-1][strconv.Itoa(day.Day())]
+	if !ok {
+		if p.fallback == nil {
+			return nil, fmt.Errorf("the Mawaqit calendar doesn't cover %s", day.Format(DATE_LAYOUT))
+		}
+		log.Printf("The Mawaqit calendar doesn't cover %s. Using the fallback prayer times.", day.Format(DATE_LAYOUT))
+		return p.fallback.PrayerTimesOn(ctx, day)
+	}
+
+	row := timetableRow{
+		file:  fmt.Sprintf("%s calendar[%d]", p.source, day.Month()-1),
+		line:  day.Day(),
+		date:  day.Format(DATE_LAYOUT),
+		times: map[string]string{},
+	}
+	for i, column := range timetableColumns {
+		row.times[column] = times[i]
+	}
+	pt, err := row.PrayerTimesOn(day)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.setIqama(pt, day); err != nil {
+		return nil, err
+	}
+	return pt, nil
+}
+
+// setIqama sets the iqama times of a day's prayers from the iqama calendar,
+// if any. An iqama before its Adhan is logged and dropped, the day's other
+// times still apply.
+func (p *MawaqitPrayerTimes) setIqama(pt *PrayerTimes, day time.Time) error {
+	if len(p.mosque.IqamaCalendar) == 0 {
+		return nil
+	}
+	iqamas, ok := p.mosque.IqamaCalendar[day.Month()-1][strconv.Itoa(day.Day())]
+	if !ok {
+		return nil
+	}
+
+	for i, pr := range []*Prayer{pt.Fajr, pt.Dhuhr, pt.Asr, pt.Maghrib, pt.Ishaa} {
+		iqama, err := parseIqama(iqamas[i], pr.Time)
+		if err != nil {
+			return fmt.Errorf("%s iqamaCalendar[%d]:%d (%s): %w", p.source, day.Month()-1, day.Day(), pr.Name, err)
+		}
+		if iqama.Before(pr.Time) {
+			log.Printf("Warning: %s iqamaCalendar[%d]:%d (%s): ignoring the iqama %v before the Adhan %v", p.source, day.Month()-1, day.Day(), pr.Name,
+				iqama.Format(TIME_LAYOUT), pr.Time.Format(TIME_LAYOUT))
+			continue
+		}
+		pr.Iqama = iqama
+	}
+	return nil
+}
+
+// parseIqama parses an iqama in minutes after its Adhan e.g. "+10" or at a
+// time of day e.g. "19:30". An empty iqama is unknown and returns zero.
+func parseIqama(iqama string, adhan time.Time) (time.Time, error) {
+	iqama = strings.TrimSpace(iqama)
+	if iqama == "" {
+		return time.Time{}, nil
+	}
+
+	if strings.HasPrefix(iqama, "+") {
+		minutes, err := strconv.Atoi(iqama[1:])
+		if err != nil || minutes < 0 {
+			return time.Time{}, fmt.Errorf("invalid iqama delay %q, expected minutes e.g. +10", iqama)
+		}
+		return adhan.Add(time.Duration(minutes) * time.Minute), nil
+	}
+
+	parsed, err := time.Parse(TIME_LAYOUT, iqama)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid iqama time %q: %w", iqama, err)
+	}
+	return WallClock(adhan, parsed.Hour(), parsed.Minute()), nil
+}
@@ -0,0 +1,240 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prayertimes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// MAWAQIT_FIXTURE is a synthetic Mawaqit calendar with iqama delays and, from
+// November to January, a fixed Ishaa iqama at 19:00. It is not a captured
+// response: its times are the static munich2023 timetable with the malformed
+// values corrected by hand, and its iqamas are made up.
+const MAWAQIT_FIXTURE = "testdata/mawaqit.json"
+
+// mawaqitCalendar returns a Mawaqit JSON with the same times every day. The
+// iqamaCalendar is omitted if iqamas is nil.
+func mawaqitCalendar(t *testing.T, months int, times, iqamas []string) string {
+	t.Helper()
+	calendar, iqamaCalendar := []map[string][]string{}, []map[string][]string{}
+	for m := 0; m < months; m++ {
+		days, iqamaDays := map[string][]string{}, map[string][]string{}
+		for d := 1; d <= 31; d++ {
+			days[strconv.Itoa(d)] = times
+			iqamaDays[strconv.Itoa(d)] = iqamas
+		}
+		calendar, iqamaCalendar = append(calendar, days), append(iqamaCalendar, iqamaDays)
+	}
+	mosque := map[string]any{"name": "Test Mosque", "calendar": calendar}
+	if iqamas != nil {
+		mosque["iqamaCalendar"] = iqamaCalendar
+	}
+	body, err := json.Marshal(mosque)
+	if err != nil {
+		t.Fatalf("Failed to encode the Mawaqit calendar: %v", err)
+	}
+	return string(body)
+}
+
+func TestMawaqitPrayerTimes(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, MAWAQIT_FIXTURE)
+	}))
+	defer server.Close()
+
+	for _, source := range []string{MAWAQIT_FIXTURE, server.URL + "/api/mosque.json"} {
+		t.Run(source, func(t *testing.T) {
+			p, err := NewMawaqitPrayerTimes(context.Background(), source)
+			if err != nil {
+				t.Fatalf("NewMawaqitPrayerTimes returned error, expected None: %v", err)
+			}
+
+			for _, test := range []struct {
+				description string
+				day         time.Time
+				// want are the Adhan and iqama times of the 5 prayers.
+				want [][2]string
+			}{
+				{
+					description: "Iqama delays and a fixed Ishaa iqama",
+					day:         time.Date(2024, time.January, 15, 10, 0, 0, 0, time.UTC),
+					want:        [][2]string{{"06:08", "06:28"}, {"12:28", "12:38"}, {"14:29", "14:39"}, {"16:51", "16:56"}, {"18:32", "19:00"}},
+				},
+				{
+					description: "Last day of a month",
+					day:         time.Date(2024, time.March, 31, 10, 0, 0, 0, time.UTC),
+					want:        [][2]string{{"05:06", "05:26"}, {"13:23", "13:33"}, {"16:52", "17:02"}, {"19:46", "19:51"}, {"21:23", "21:33"}},
+				},
+			} {
+				t.Run(test.description, func(t *testing.T) {
+					if err := p.GetTodayPrayerTimes(context.Background(), test.day); err != nil {
+						t.Fatalf("GetTodayPrayerTimes returned error, expected None: %v", err)
+					}
+					for i, pr := range []*Prayer{p.Fajr, p.Dhuhr, p.Asr, p.Maghrib, p.Ishaa} {
+						got := [2]string{pr.Time.Format(TIME_LAYOUT), pr.Iqama.Format(TIME_LAYOUT)}
+						if got != test.want[i] || !isSameDay(test.day, pr.Time, pr.Iqama) {
+							t.Errorf("%v mismatch. Got %v (iqama %v), want %v", pr.Name, pr.Time, pr.Iqama, test.want[i])
+						}
+					}
+					if p.Sunrise == nil || !p.Sunrise.Iqama.IsZero() {
+						t.Errorf("Sunrise should be set without an iqama. Got %v", p.Sunrise)
+					}
+				})
+			}
+		})
+	}
+}
+
+func TestMawaqitPrayerTimesWithoutIqama(t *testing.T) {
+	calendar := mawaqitCalendar(t, 12, []string{"05:00", "06:30", "12:00", "15:00", "18:00", "19:30"}, nil)
+
+	p, err := NewMawaqitPrayerTimes(context.Background(), writeTimetable(t, "mosque.json", calendar))
+	if err != nil {
+		t.Fatalf("NewMawaqitPrayerTimes returned error, expected None: %v", err)
+	}
+	for _, pr := range p.Prayers() {
+		if !pr.Iqama.IsZero() {
+			t.Errorf("%v iqama should be unknown. Got %v", pr.Name, pr.Iqama)
+		}
+	}
+}
+
+func TestInvalidMawaqitPrayerTimes(t *testing.T) {
+	times := []string{"05:00", "06:30", "12:00", "15:00", "18:00", "19:30"}
+	iqamas := []string{"+20", "+10", "+10", "+5", "+10"}
+
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	for _, test := range []struct {
+		description string
+		content     string
+		source      string
+	}{
+		{
+			description: "Missing source",
+		},
+		{
+			description: "Missing file",
+			source:      "testdata/missing.json",
+		},
+		{
+			description: "Unsuccessful response",
+			source:      server.URL + "/api/mosque.json",
+		},
+		{
+			description: "Malformed JSON",
+			content:     `{"calendar": [`,
+		},
+		{
+			description: "Missing months",
+			content:     mawaqitCalendar(t, 11, times, iqamas),
+		},
+		{
+			description: "Missing a prayer",
+			content:     mawaqitCalendar(t, 12, times[:5], iqamas),
+		},
+		{
+			description: "Missing an iqama",
+			content:     mawaqitCalendar(t, 12, times, iqamas[:4]),
+		},
+		{
+			description: "Malformed time",
+			content:     mawaqitCalendar(t, 12, []string{"05:00", "06:30", "12:00", "15:00", "18:00", "7:30pm"}, iqamas),
+		},
+		{
+			description: "Malformed iqama delay",
+			content:     mawaqitCalendar(t, 12, times, []string{"+20", "+10", "+ten", "+5", "+10"}),
+		},
+	} {
+		t.Run(test.description, func(t *testing.T) {
+			source := test.source
+			if test.content != "" {
+				source = writeTimetable(t, "mosque.json", test.content)
+			}
+			if _, err := NewMawaqitPrayerTimes(context.Background(), source); err == nil {
+				t.Errorf("NewMawaqitPrayerTimes should return an error. Got none.")
+			}
+		})
+	}
+
+	t.Run("Cancelled request", func(t *testing.T) {
+		calendar := mawaqitCalendar(t, 12, times, iqamas)
+		mosque := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(calendar))
+		}))
+		defer mosque.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if _, err := NewMawaqitPrayerTimes(ctx, mosque.URL); !errors.Is(err, context.Canceled) {
+			t.Errorf("NewMawaqitPrayerTimes with a cancelled context should return context.Canceled. Got %v", err)
+		}
+	})
+}
+
+func TestMawaqitIqamaBeforeAdhan(t *testing.T) {
+	calendar := mawaqitCalendar(t, 12, []string{"05:00", "06:30", "12:00", "15:00", "18:00", "19:30"}, []string{"+20", "+10", "+10", "+5", "19:00"})
+	mosque, err := parseMawaqit([]byte(calendar), "mosque.json")
+	if err != nil {
+		t.Fatalf("parseMawaqit returned error, expected None: %v", err)
+	}
+
+	p := &MawaqitPrayerTimes{source: "mosque.json", mosque: mosque}
+	day := time.Date(2024, time.March, 1, 10, 0, 0, 0, time.UTC)
+	got, err := p.PrayerTimesOn(context.Background(), day)
+	if err != nil {
+		t.Fatalf("PrayerTimesOn returned error, expected None: %v", err)
+	}
+	if !got.Ishaa.Iqama.IsZero() {
+		t.Errorf("Ishaa's iqama before the Adhan should be dropped. Got %v", got.Ishaa.Iqama)
+	}
+	if want := time.Date(2024, time.March, 1, 18, 5, 0, 0, time.UTC); !got.Maghrib.Iqama.Equal(want) {
+		t.Errorf("Maghrib iqama mismatch. Got %v, want %v", got.Maghrib.Iqama, want)
+	}
+}
+
+func TestMawaqitPrayerTimesFallback(t *testing.T) {
+	calendar := mawaqitCalendar(t, 12, []string{"05:00", "06:30", "12:00", "15:00", "18:00", "19:30"}, nil)
+	mosque, err := parseMawaqit([]byte(calendar), "mosque.json")
+	if err != nil {
+		t.Fatalf("parseMawaqit returned error, expected None: %v", err)
+	}
+	delete(mosque.Calendar[time.March-1], "1")
+
+	p := &MawaqitPrayerTimes{source: "mosque.json", mosque: mosque}
+	day := time.Date(2024, time.March, 1, 10, 0, 0, 0, time.UTC)
+	if _, err := p.PrayerTimesOn(context.Background(), day); err == nil {
+		t.Errorf("PrayerTimesOn of an uncovered day should return an error. Got none.")
+	}
+
+	calculated := &CalculatedPrayerTimes{params: munichParams}
+	Fallback(calculated)(&p.PrayerTimes)
+	got, err := p.PrayerTimesOn(context.Background(), day)
+	if err != nil {
+		t.Fatalf("PrayerTimesOn returned error, expected None: %v", err)
+	}
+	want, _ := calculated.PrayerTimesOn(context.Background(), day)
+	if !got.Fajr.Time.Equal(want.Fajr.Time) {
+		t.Errorf("Fajr mismatch. Got %v, want the calculated %v", got.Fajr.Time, want.Fajr.Time)
+	}
+}
@@ -12,76 +12,335 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
-// handles prayer times geographicacl calculations and times till next prayers
-// calculations.
+// Package prayertimes handles prayer times geographicacl calculations and times
+// till next prayers calculations.
 
-package main
+package prayertimes
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"sort"
+	"strings"
 	"time"
+
+	"github.com/ssafty/adhan-homeassistant-pi/clock"
 )
 
-type prayer struct {
-	name string
-	time time.Time
+// Prayer is a prayer or another time of the day e.g. Sunrise.
+type Prayer struct {
+	Name string
+	Time time.Time
+	// Iqama is the start of the congregational prayer published by a mosque.
+	// It is zero if unknown.
+	Iqama time.Time
+}
+
+// String returns the prayer name and time e.g. "Fajr 05:01", followed by the
+// iqama if known e.g. "Fajr 05:01 (iqama 05:20)".
+func (p *Prayer) String() string {
+	if !p.Iqama.IsZero() {
+		return fmt.Sprintf("%v %v (iqama %v)", p.Name, p.Time.Format("15:04"), p.Iqama.Format("15:04"))
+	}
+	return fmt.Sprintf("%v %v", p.Name, p.Time.Format("15:04"))
 }
 
 // returns duration from now till an input prayer.
-func (p *prayer) TimeToPrayer(now time.Time) time.Duration {
-	if now.Compare(p.time) > 0 {
+func (p *Prayer) TimeToPrayer(now time.Time) time.Duration {
+	if now.Compare(p.Time) > 0 {
 		// prayer has passed
-		return now.Sub(p.time)
+		return now.Sub(p.Time)
 	}
 	// prayer is coming
-	return p.time.Sub(now)
+	return p.Time.Sub(now)
 }
 
 // IPrayerTimes is an interface to be used by specific cities or a global
 // prayer time calculator.
 type IPrayerTimes interface {
-	GetTodayPrayerTimes(now time.Time) error
-	GetNearestPrayers(now time.Time) (*prayer, *prayer, error)
+	GetTodayPrayerTimes(ctx context.Context, now time.Time) error
+	GetNearestPrayers(now time.Time) (*Prayer, *Prayer, error)
+	// GetPrayerTimes returns the prayer times of any day e.g. yesterday's.
+	GetPrayerTimes(ctx context.Context, day time.Time) (*PrayerTimes, error)
+	// GetDays returns the days loaded by GetTodayPrayerTimes.
+	GetDays() []*PrayerTimes
+	// GetHijriDate returns today's Islamic date.
+	GetHijriDate() HijriDate
+}
+
+// PrayerTimes contains all 5 prayers and the date (yyyy-mm-dd) for caching.
+type PrayerTimes struct {
+	Fajr    *Prayer
+	Dhuhr   *Prayer
+	Asr     *Prayer
+	Maghrib *Prayer
+	Ishaa   *Prayer
+
+	// SecondAsr is an optional Asr prayer following the other juristic
+	// convention e.g. Hanafi's Asr if Asr is Shafi'i's.
+	SecondAsr *Prayer
+
+	// Sunrise (Shuruq) marks the end of Fajr. It is nil if a timetable doesn't
+	// have a sunrise column.
+	Sunrise *Prayer
+	// Duha, Midnight and LastThird are computed by load from Sunrise and the
+	// night between Maghrib and the next day's Fajr. They are nil if unknown.
+	Duha      *Prayer
+	Midnight  *Prayer
+	LastThird *Prayer
+
+	Date time.Time
+	// hijriDate is the Islamic date of Date.
+	hijriDate HijriDate
+
+	// yesterday and tomorrow are used by GetNearestPrayers before Fajr and
+	// after Ishaa.
+	yesterday *PrayerTimes
+	tomorrow  *PrayerTimes
+	// yesterdayErr and tomorrowErr are why an adjacent day is missing.
+	yesterdayErr error
+	tomorrowErr  error
+
+	// offsets fine-tune the prayer times per prayer name e.g. "Fajr": 2 minutes.
+	offsets map[string]time.Duration
+	// fallback provides the prayer times of days not covered by a provider.
+	fallback DailyPrayerTimes
+	// timezone of the prayer times. If nil, the location of the timestamps
+	// passed to GetTodayPrayerTimes is used.
+	timezone *time.Location
+	// hijriAdjustment shifts the Hijri dates by days for local moon sighting.
+	hijriAdjustment int
+	// clock reads today's date on initialization. It defaults to the system
+	// clock.
+	clock clock.Clock
+}
+
+// DailyPrayerTimes returns the prayer times of the day of a timestamp, without
+// offsets. It is implemented by all IPrayerTimes of this package. ctx bounds
+// fetching the prayer times online e.g. from Aladhan.
+type DailyPrayerTimes interface {
+	PrayerTimesOn(ctx context.Context, day time.Time) (*PrayerTimes, error)
+}
+
+// DUHA_DELAY is the time after sunrise when the sun has risen the length of a
+// spear and Duha (Ishraq) starts.
+const DUHA_DELAY = 15 * time.Minute
+
+type PrayerTimesOpt func(*PrayerTimes)
+
+// Offsets shifts the prayer times of any IPrayerTimes implementation before
+// they are used by GetNearestPrayers. Keys are prayer names e.g. "Maghrib".
+func Offsets(o map[string]time.Duration) PrayerTimesOpt {
+	return func(p *PrayerTimes) {
+		p.offsets = o
+	}
+}
+
+// Fallback serves the days not covered by a table backed IPrayerTimes e.g.
+// from the prayer times calculator.
+func Fallback(f DailyPrayerTimes) PrayerTimesOpt {
+	return func(p *PrayerTimes) {
+		p.fallback = f
+	}
+}
+
+// Timezone expresses the prayer times and their days in an IANA timezone
+// instead of the location of the timestamps e.g. time.Now()'s.
+func Timezone(loc *time.Location) PrayerTimesOpt {
+	return func(p *PrayerTimes) {
+		p.timezone = loc
+	}
+}
+
+// HijriAdjustment shifts the Hijri dates by ±days to follow local moon sighting.
+func HijriAdjustment(days int) PrayerTimesOpt {
+	return func(p *PrayerTimes) {
+		p.hijriAdjustment = days
+	}
+}
+
+// PrayerTimesClock replaces the system clock reading today's date e.g. by a
+// fake clock in simulations.
+func PrayerTimesClock(c clock.Clock) PrayerTimesOpt {
+	return func(p *PrayerTimes) {
+		p.clock = c
+	}
+}
+
+// in converts a timestamp to the configured timezone, if any.
+func (p *PrayerTimes) in(t time.Time) time.Time {
+	if p.timezone == nil {
+		return t
+	}
+	return t.In(p.timezone)
+}
+
+// Load populates p with the prayer times of now's day and its adjacent days
+// from source.
+func (p *PrayerTimes) Load(ctx context.Context, now time.Time, source DailyPrayerTimes) error {
+	now = p.in(now)
+	today, err := p.adjust(source.PrayerTimesOn(ctx, AdjacentDay(now, 0)))
+	if err != nil {
+		return err
+	}
+	// The adjacent days are optional e.g. on the first and last day of a
+	// timetable. GetNearestPrayers fails only if it needs a missing day.
+	yesterday, yesterdayErr := p.adjust(source.PrayerTimesOn(ctx, AdjacentDay(now, -1)))
+	if yesterdayErr != nil {
+		log.Printf("Warning: yesterday's prayer times are not available: %v", yesterdayErr)
+	}
+	tomorrow, tomorrowErr := p.adjust(source.PrayerTimesOn(ctx, AdjacentDay(now, 1)))
+	if tomorrowErr != nil {
+		log.Printf("Warning: tomorrow's prayer times are not available: %v", tomorrowErr)
+	}
+
+	if yesterday != nil {
+		yesterday.setExtras(today)
+	}
+	today.setExtras(tomorrow)
+	if tomorrow != nil {
+		tomorrow.setExtras(nil)
+	}
+
+	p.Fajr = today.Fajr
+	p.Sunrise = today.Sunrise
+	p.Duha = today.Duha
+	p.Dhuhr = today.Dhuhr
+	p.Asr = today.Asr
+	p.SecondAsr = today.SecondAsr
+	p.Maghrib = today.Maghrib
+	p.Ishaa = today.Ishaa
+	p.Midnight = today.Midnight
+	p.LastThird = today.LastThird
+	p.Date = today.Date
+	p.hijriDate = today.hijriDate
+	p.yesterday, p.tomorrow = yesterday, tomorrow
+	p.yesterdayErr, p.tomorrowErr = yesterdayErr, tomorrowErr
+	return nil
+}
+
+// setExtras computes Duha from Sunrise and, given the next day, the Islamic
+// midnight and the start of the last third of the night from Maghrib to Fajr.
+func (p *PrayerTimes) setExtras(next *PrayerTimes) {
+	if p.Sunrise != nil {
+		p.Duha = &Prayer{Name: "Duha", Time: p.Sunrise.Time.Add(DUHA_DELAY)}
+	}
+	if next == nil {
+		return
+	}
+	night := next.Fajr.Time.Sub(p.Maghrib.Time)
+	p.Midnight = &Prayer{Name: "Midnight", Time: p.Maghrib.Time.Add(night / 2).Round(time.Minute)}
+	p.LastThird = &Prayer{Name: "Last third", Time: p.Maghrib.Time.Add(night * 2 / 3).Round(time.Minute)}
 }
 
-// prayerTimes contains all 5 prayers and the date (yyyy-mm-dd) for caching.
-type prayerTimes struct {
-	Fajr    *prayer
-	Dhuhr   *prayer
-	Asr     *prayer
-	Maghrib *prayer
-	Ishaa   *prayer
+// GetDays returns yesterday's, today's and tomorrow's prayer times loaded by
+// GetTodayPrayerTimes. Adjacent days that are not covered are omitted.
+func (p *PrayerTimes) GetDays() []*PrayerTimes {
+	days := []*PrayerTimes{}
+	for _, day := range []*PrayerTimes{p.yesterday, p, p.tomorrow} {
+		if day != nil {
+			days = append(days, day)
+		}
+	}
+	return days
+}
+
+// AdjacentDay returns noon of the n-th day after now's day.
+func AdjacentDay(now time.Time, n int) time.Time {
+	return time.Date(now.Year(), now.Month(), now.Day()+n, 12, 0, 0, 0, now.Location())
+}
+
+// adjust shifts the prayers of a day by the configured offsets and sets its
+// Hijri date. The Asr offset applies to both Asr prayers. Iqama times are
+// published by the mosque and are not shifted.
+func (p *PrayerTimes) adjust(day *PrayerTimes, err error) (*PrayerTimes, error) {
+	if err != nil {
+		return nil, err
+	}
+	day.hijriDate = ToHijri(day.Date, p.hijriAdjustment)
+
+	for _, pr := range []struct {
+		offset string
+		prayer *Prayer
+	}{
+		{"Fajr", day.Fajr},
+		{"Dhuhr", day.Dhuhr},
+		{"Asr", day.Asr},
+		{"Asr", day.SecondAsr},
+		{"Maghrib", day.Maghrib},
+		{"Ishaa", day.Ishaa},
+	} {
+		offset, ok := p.offsets[pr.offset]
+		if !ok || offset == 0 || pr.prayer == nil {
+			continue
+		}
+		shifted := pr.prayer.Time.Add(offset)
+		log.Printf("Applying offset %v to %v on %v: %v -> %v", offset, pr.prayer.Name, day.Date.Format(DATE_LAYOUT),
+			pr.prayer.Time.Format("15:04"), shifted.Format("15:04"))
+		pr.prayer.Time = shifted
+	}
+	return day, nil
+}
 
-	date time.Time
+// Prayers returns the prayers of the day sorted by time.
+func (p *PrayerTimes) Prayers() []*Prayer {
+	ps := []*Prayer{p.Fajr, p.Dhuhr, p.Asr, p.Maghrib, p.Ishaa}
+	if p.SecondAsr != nil {
+		ps = append(ps, p.SecondAsr)
+	}
+	sort.SliceStable(ps, func(i, j int) bool { return ps[i].Time.Before(ps[j].Time) })
+	return ps
+}
+
+// String returns the Gregorian and Hijri dates and the times of the day.
+func (p PrayerTimes) String() string {
+	times := []string{}
+	for _, pr := range []*Prayer{p.Fajr, p.Sunrise, p.Duha, p.Dhuhr, p.Asr, p.SecondAsr, p.Maghrib, p.Ishaa, p.Midnight, p.LastThird} {
+		if pr != nil {
+			times = append(times, pr.String())
+		}
+	}
+	return fmt.Sprintf("%v (%v): %v", p.Date.Format(DATE_LAYOUT), p.hijriDate, strings.Join(times, ", "))
+}
+
+// GetHijriDate returns the Islamic date of the day of the prayer times.
+func (p *PrayerTimes) GetHijriDate() HijriDate {
+	return p.hijriDate
+}
+
+// Extras returns the known non-adhan times of the day e.g. Sunrise.
+func (p *PrayerTimes) Extras() []*Prayer {
+	ps := []*Prayer{}
+	for _, pr := range []*Prayer{p.Sunrise, p.Duha, p.Midnight, p.LastThird} {
+		if pr != nil {
+			ps = append(ps, pr)
+		}
+	}
+	return ps
 }
 
 // GetNearestPrayers returns the previous and next *prayers given a timestamp.
-func (p *prayerTimes) GetNearestPrayers(now time.Time) (*prayer, *prayer, error) {
+// Before Fajr and after Ishaa, the adjacent days' prayers are used and fail if
+// the adjacent day is not covered.
+func (p *PrayerTimes) GetNearestPrayers(now time.Time) (*Prayer, *Prayer, error) {
+	prayers := []*Prayer{}
+	for _, day := range p.GetDays() {
+		prayers = append(prayers, day.Prayers()...)
+	}
+	for i := 1; i < len(prayers); i++ {
+		if isBetweenPrayers(prayers[i-1].Time, now, prayers[i].Time) {
+			return prayers[i-1], prayers[i], nil
+		}
+	}
+	today := p.Prayers()
 	switch {
-	case now.Equal(p.Fajr.time) || now.Before(p.Fajr.time):
-		// TODO(ssafty): use exact prayer times from previous day.
-		ishaa_last_day := &prayer{
-			name: "Ishaa",
-			time: p.Ishaa.time.Add(-23 * time.Hour)}
-		return ishaa_last_day, p.Fajr, nil
-	case isBetweenPrayers(p.Fajr.time, now, p.Dhuhr.time):
-		return p.Fajr, p.Dhuhr, nil
-	case isBetweenPrayers(p.Dhuhr.time, now, p.Asr.time):
-		return p.Dhuhr, p.Asr, nil
-	case isBetweenPrayers(p.Asr.time, now, p.Maghrib.time):
-		return p.Asr, p.Maghrib, nil
-	case isBetweenPrayers(p.Maghrib.time, now, p.Ishaa.time):
-		return p.Maghrib, p.Ishaa, nil
-	case now.After(p.Ishaa.time):
-		// TODO(ssafty): use exact prayer times from next day.
-		fajr_next_day := &prayer{
-			name: "Fajr",
-			time: p.Fajr.time.Add(23 * time.Hour)}
-		return p.Ishaa, fajr_next_day, nil
-	default:
-		return nil, nil, fmt.Errorf("Failed to find Time to closest prayer for timestamp: %v and prayerTimes: %v", now, p)
+	case p.yesterday == nil && !now.After(today[0].Time):
+		return nil, nil, fmt.Errorf("Failed to find Time to closest prayer for timestamp: %v. Yesterday is not covered: %w", now, p.yesterdayErr)
+	case p.tomorrow == nil && now.After(today[len(today)-1].Time):
+		return nil, nil, fmt.Errorf("Failed to find Time to closest prayer for timestamp: %v. Tomorrow is not covered: %w", now, p.tomorrowErr)
 	}
+	return nil, nil, fmt.Errorf("Failed to find Time to closest prayer for timestamp: %v and PrayerTimes: %v", now, p)
 }
 
 // isSameDay returns True if all input timestamps have the same date.
@@ -110,57 +369,80 @@ func GetDate(n time.Time) time.Time {
 	return time.Date(n.Year(), n.Month(), n.Day(), 0, 0, 0, 0, n.Location())
 }
 
-// Munich.
-
-// munichPrayerTimes extends prayerTimes to reuse GetNearestPrayers.
-type munichPrayerTimes struct {
-	prayerTimes
-}
-
-func NewMunichPrayerTimes() (*munichPrayerTimes, error) {
-	pt := &munichPrayerTimes{}
-	if err := pt.GetTodayPrayerTimes(time.Now()); err != nil {
-		return nil, fmt.Errorf("Error initializing NewPrayerTimes for %s: %w", time.Now().Format("2006-01-02"), err)
+// WallClock returns the first instant a day's wall clock shows hour:min.
+// On daylight saving time changes, a nonexistent time (e.g. 02:30 when
+// clocks jump from 02:00 to 03:00) maps to the end of the gap and a repeated
+// time maps to its first occurrence.
+func WallClock(day time.Time, hour, min int) time.Time {
+	t := time.Date(day.Year(), day.Month(), day.Day(), hour, min, 0, 0, day.Location())
+	start, _ := t.ZoneBounds()
+	if t.Hour() != hour || t.Minute() != min {
+		// time.Date normalized a time within the gap past its end.
+		return start
 	}
-	return pt, nil
+	if !start.IsZero() {
+		_, offset := t.Zone()
+		_, prevOffset := start.Add(-time.Nanosecond).Zone()
+		earlier := t.Add(-time.Duration(prevOffset-offset) * time.Second)
+		if earlier.Before(start) && earlier.Hour() == hour && earlier.Minute() == min {
+			return earlier
+		}
+	}
+	return t
 }
 
-// GetTodayPrayerTimes is specific to Munich as it reads from static prayer times.
-func (p *munichPrayerTimes) GetTodayPrayerTimes(now time.Time) error {
-	if !p.date.IsZero() && p.date == GetDate(now) {
-		return nil
-	}
+// Munich.
 
-	pts := []time.Time{}
-	for i := 0; i < 6; i++ {
-		t := munich2023[now.Month()-1][6*(now.Day()-1)+i]
-		parsed, err := time.Parse("15:04", t)
-		if err != nil {
-			return fmt.Errorf("Error parsing prayertime %v: %w", t, err)
-		}
+const (
+	MUNICH_LATITUDE  = 48.1374
+	MUNICH_LONGITUDE = 11.5755
+)
 
-		tt := time.Date(now.Year(), now.Month(), now.Day(), parsed.Hour(), parsed.Minute(), 0, 0, now.Location())
+// MunichPrayerTimes is a timetable of the static Munich prayer times.
+type MunichPrayerTimes struct {
+	TimetablePrayerTimes
+}
 
-		if i == 1 {
-			// We don't consider Ishraq.
-			continue
-		}
-		pts = append(pts, tt)
+func NewMunichPrayerTimes(opts ...PrayerTimesOpt) (*MunichPrayerTimes, error) {
+	rows := munichTimetable(2023, munich2023)
+	logTimetableIssues(rows)
+
+	days, err := newTimetableDays(rows)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading the Munich timetable: %w", err)
 	}
 
-	p.Fajr = &prayer{name: "Fajr", time: pts[0]}
-	p.Dhuhr = &prayer{name: "Dhuhr", time: pts[1]}
-	p.Asr = &prayer{name: "Asr", time: pts[2]}
-	p.Maghrib = &prayer{name: "Maghrib", time: pts[3]}
-	p.Ishaa = &prayer{name: "Ishaa", time: pts[4]}
-	p.date = GetDate(now)
+	pt := &MunichPrayerTimes{TimetablePrayerTimes{days: days}}
+	for _, opt := range opts {
+		opt(&pt.PrayerTimes)
+	}
 
-	if !isSameDay(now, p.Fajr.time, p.Dhuhr.time, p.Asr.time, p.Maghrib.time, p.Ishaa.time) {
-		return fmt.Errorf("Failed to find time to closest prayer. Found Inconsistency of dates between now (%v) and today's prayers(%v)", now, p)
+	now := clock.OrSystem(pt.clock).Now()
+	if err := pt.GetTodayPrayerTimes(context.Background(), now); err != nil {
+		return nil, fmt.Errorf("Error initializing NewPrayerTimes for %s: %w", now.Format("2006-01-02"), err)
 	}
+	return pt, nil
+}
 
-	log.Printf("PrayerTimes today: %v", *p)
-	return nil
+// munichTimetable converts a static yearly table of 12 months with six columns
+// per day (Fajr, Sunrise, Dhuhr, Asr, Maghrib, Ishaa) to timetable rows.
+func munichTimetable(year int, table [][]string) []timetableRow {
+	rows := []timetableRow{}
+	for m, month := range table {
+		for d := 0; d < len(month)/len(timetableColumns); d++ {
+			row := timetableRow{
+				file:  fmt.Sprintf("munich%d[%d]", year, m),
+				line:  d + 1,
+				date:  time.Date(year, time.Month(m+1), d+1, 0, 0, 0, 0, time.UTC).Format(DATE_LAYOUT),
+				times: map[string]string{},
+			}
+			for i, c := range timetableColumns {
+				row.times[c] = month[len(timetableColumns)*d+i]
+			}
+			rows = append(rows, row)
+		}
+	}
+	return rows
 }
 
 // source: https://www.islamisches-zentrum-muenchen.de/
@@ -0,0 +1,360 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prayertimes
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTimeToClosestPrayer(t *testing.T) {
+	parse := func(s string) time.Time {
+		c, err := time.Parse("15:04", s)
+		if err != nil {
+			t.Fatalf("Failed to parse the time %v: %v", s, err)
+		}
+		return c
+	}
+
+	day := func(offset time.Duration) *PrayerTimes {
+		return &PrayerTimes{
+			Fajr:    &Prayer{Time: parse("09:00").Add(offset)},
+			Dhuhr:   &Prayer{Time: parse("12:00").Add(offset)},
+			Asr:     &Prayer{Time: parse("15:00").Add(offset)},
+			Maghrib: &Prayer{Time: parse("18:00").Add(offset)},
+			Ishaa:   &Prayer{Time: parse("21:00").Add(offset)},
+		}
+	}
+
+	PrayerTimes := day(0)
+	PrayerTimes.yesterday = day(-24 * time.Hour)
+	// Tomorrow's Fajr is 10 minutes earlier.
+	PrayerTimes.tomorrow = day(24*time.Hour - 10*time.Minute)
+
+	for _, test := range []struct {
+		description string
+		clock       string
+		wantPrev    time.Duration
+		wantNext    time.Duration
+	}{
+		{
+			description: "40 minutes before Fajr",
+			clock:       "08:20",
+			// Yesterday's Ishaa.
+			wantPrev: time.Hour*11 + time.Minute*20,
+			wantNext: time.Minute * 40,
+		},
+		{
+			description: "30 minutes after Fajr",
+			clock:       "09:30",
+			wantPrev:    time.Minute * 30,
+			wantNext:    time.Hour*2 + time.Minute*30,
+		},
+		{
+			description: "30 minutes before Dhuhr",
+			clock:       "11:30",
+			wantPrev:    time.Hour*2 + time.Minute*30,
+			wantNext:    time.Minute * 30,
+		},
+		{
+			description: "30 minutes after Dhuhr",
+			clock:       "12:30",
+			wantPrev:    time.Minute * 30,
+			wantNext:    time.Hour*2 + time.Minute*30,
+		},
+		{
+			description: "30 minutes before Asr",
+			clock:       "14:30",
+			wantPrev:    time.Hour*2 + time.Minute*30,
+			wantNext:    time.Minute * 30,
+		},
+		{
+			description: "30 minutes after Asr",
+			clock:       "15:30",
+			wantPrev:    time.Minute * 30,
+			wantNext:    time.Hour*2 + time.Minute*30,
+		},
+		{
+			description: "30 minutes before Maghrib",
+			clock:       "17:30",
+			wantPrev:    time.Hour*2 + time.Minute*30,
+			wantNext:    time.Minute * 30,
+		},
+		{
+			description: "30 minutes after Maghrib",
+			clock:       "18:30",
+			wantPrev:    time.Minute * 30,
+			wantNext:    time.Hour*2 + time.Minute*30,
+		},
+		{
+			description: "30 minutes before Ishaa",
+			clock:       "20:30",
+			wantPrev:    time.Hour*2 + time.Minute*30,
+			wantNext:    time.Minute * 30,
+		},
+		{
+			description: "30 minutes after Ishaa",
+			clock:       "21:30",
+			wantPrev:    time.Minute * 30,
+			// Tomorrow's Fajr.
+			wantNext: time.Hour*11 + time.Minute*20,
+		},
+		{
+			description: "Midnight",
+			clock:       "00:00",
+			wantPrev:    time.Hour * 3,
+			wantNext:    time.Hour * 9,
+		},
+		{
+			description: "Dhuhr Time",
+			clock:       "12:00",
+			wantPrev:    time.Hour * 3,
+			wantNext:    0,
+		},
+	} {
+		t.Run(test.description, func(t *testing.T) {
+			now := parse(test.clock)
+			prev, next, err := PrayerTimes.GetNearestPrayers(now)
+			if err != nil {
+				t.Fatalf("TimeToClosestPrayer returned error, expected None: %v", err)
+			}
+			prevTime := prev.TimeToPrayer(now)
+			nextTime := next.TimeToPrayer(now)
+
+			if test.wantPrev != prevTime {
+				t.Errorf("Time to previous prayer mismatch. Want %v got %v", test.wantPrev, prevTime)
+			}
+			if test.wantNext != nextTime {
+				t.Errorf("Time to next prayer mismatch. Want %v got %v", test.wantNext, nextTime)
+			}
+		})
+	}
+}
+
+// shiftDay returns a copy of the prayers of day shifted by d.
+func shiftDay(day *PrayerTimes, d time.Duration) *PrayerTimes {
+	shifted := &PrayerTimes{}
+	for dst, src := range map[**Prayer]*Prayer{
+		&shifted.Fajr:      day.Fajr,
+		&shifted.Dhuhr:     day.Dhuhr,
+		&shifted.Asr:       day.Asr,
+		&shifted.SecondAsr: day.SecondAsr,
+		&shifted.Maghrib:   day.Maghrib,
+		&shifted.Ishaa:     day.Ishaa,
+	} {
+		if src != nil {
+			*dst = &Prayer{Name: src.Name, Time: src.Time.Add(d)}
+		}
+	}
+	return shifted
+}
+
+func TestNearestPrayersWithSecondAsr(t *testing.T) {
+	parse := func(s string) time.Time {
+		c, err := time.Parse("15:04", s)
+		if err != nil {
+			t.Fatalf("Failed to parse the time %v: %v", s, err)
+		}
+		return c
+	}
+
+	PrayerTimes := &PrayerTimes{
+		Fajr:      &Prayer{Name: "Fajr", Time: parse("09:00")},
+		Dhuhr:     &Prayer{Name: "Dhuhr", Time: parse("12:00")},
+		Asr:       &Prayer{Name: "Asr", Time: parse("15:00")},
+		SecondAsr: &Prayer{Name: "Asr (Hanafi)", Time: parse("16:00")},
+		Maghrib:   &Prayer{Name: "Maghrib", Time: parse("18:00")},
+		Ishaa:     &Prayer{Name: "Ishaa", Time: parse("21:00")},
+	}
+	PrayerTimes.yesterday = shiftDay(PrayerTimes, -24*time.Hour)
+	PrayerTimes.tomorrow = shiftDay(PrayerTimes, 24*time.Hour)
+
+	for _, test := range []struct {
+		clock    string
+		wantPrev string
+		wantNext string
+	}{
+		{clock: "15:30", wantPrev: "Asr", wantNext: "Asr (Hanafi)"},
+		{clock: "16:00", wantPrev: "Asr", wantNext: "Asr (Hanafi)"},
+		{clock: "16:30", wantPrev: "Asr (Hanafi)", wantNext: "Maghrib"},
+	} {
+		t.Run(test.clock, func(t *testing.T) {
+			prev, next, err := PrayerTimes.GetNearestPrayers(parse(test.clock))
+			if err != nil {
+				t.Fatalf("GetNearestPrayers returned error, expected None: %v", err)
+			}
+			if prev.Name != test.wantPrev || next.Name != test.wantNext {
+				t.Errorf("Nearest prayers mismatch. Got (%v, %v), want (%v, %v)", prev.Name, next.Name, test.wantPrev, test.wantNext)
+			}
+		})
+	}
+}
+
+func TestMunichPrayerTimesOffsets(t *testing.T) {
+	now := time.Date(2023, time.January, 2, 10, 0, 0, 0, time.UTC)
+	at := func(s string) time.Time {
+		c, err := time.Parse("15:04", s)
+		if err != nil {
+			t.Fatalf("Failed to parse the time %v: %v", s, err)
+		}
+		return time.Date(now.Year(), now.Month(), now.Day(), c.Hour(), c.Minute(), 0, 0, now.Location())
+	}
+
+	days, err := newTimetableDays(munichTimetable(2023, munich2023))
+	if err != nil {
+		t.Fatalf("newTimetableDays returned error, expected None: %v", err)
+	}
+	p := &MunichPrayerTimes{TimetablePrayerTimes{days: days}}
+	Offsets(map[string]time.Duration{
+		"Fajr":    2 * time.Minute,
+		"Maghrib": 3 * time.Minute,
+		"Ishaa":   -1 * time.Minute,
+	})(&p.PrayerTimes)
+
+	if err := p.GetTodayPrayerTimes(context.Background(), now); err != nil {
+		t.Fatalf("GetTodayPrayerTimes returned error, expected None: %v", err)
+	}
+
+	// 2023-01-02: 06:11, 12:23, 14:15, 16:36, 18:18
+	for _, test := range []struct {
+		got  *Prayer
+		want time.Time
+	}{
+		{p.Fajr, at("06:13")},
+		{p.Dhuhr, at("12:23")},
+		{p.Asr, at("14:15")},
+		{p.Maghrib, at("16:39")},
+		{p.Ishaa, at("18:17")},
+	} {
+		if !test.got.Time.Equal(test.want) {
+			t.Errorf("%v time mismatch. Got %v, want %v", test.got.Name, test.got.Time, test.want)
+		}
+	}
+}
+
+func TestMunichPrayerTimesExtras(t *testing.T) {
+	now := time.Date(2023, time.January, 2, 10, 0, 0, 0, time.UTC)
+	days, err := newTimetableDays(munichTimetable(2023, munich2023))
+	if err != nil {
+		t.Fatalf("newTimetableDays returned error, expected None: %v", err)
+	}
+	p := &MunichPrayerTimes{TimetablePrayerTimes{days: days}}
+	if err := p.GetTodayPrayerTimes(context.Background(), now); err != nil {
+		t.Fatalf("GetTodayPrayerTimes returned error, expected None: %v", err)
+	}
+
+	// 2023-01-02 Sunrise 07:59 and Maghrib 16:36. 2023-01-03 Fajr 06:11.
+	for _, test := range []struct {
+		got  *Prayer
+		want time.Time
+	}{
+		{p.Sunrise, time.Date(2023, time.January, 2, 7, 59, 0, 0, time.UTC)},
+		{p.Duha, time.Date(2023, time.January, 2, 8, 14, 0, 0, time.UTC)},
+		// Half of the 13h35m night, rounded up.
+		{p.Midnight, time.Date(2023, time.January, 2, 23, 24, 0, 0, time.UTC)},
+		{p.LastThird, time.Date(2023, time.January, 3, 1, 39, 0, 0, time.UTC)},
+	} {
+		if test.got == nil {
+			t.Errorf("Extra time is missing, want %v", test.want)
+			continue
+		}
+		if !test.got.Time.Equal(test.want) {
+			t.Errorf("%v time mismatch. Got %v, want %v", test.got.Name, test.got.Time, test.want)
+		}
+	}
+
+	// Extras are not prayers.
+	_, next, err := p.GetNearestPrayers(time.Date(2023, time.January, 2, 7, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("GetNearestPrayers returned error, expected None: %v", err)
+	}
+	if next != p.Dhuhr {
+		t.Errorf("Next prayer mismatch. Got %v, want Dhuhr", next.Name)
+	}
+
+	// Tomorrow's night depends on the day after.
+	if tomorrow := p.GetDays()[2]; tomorrow.Duha == nil || tomorrow.Midnight != nil {
+		t.Errorf("Tomorrow's extras mismatch. Got Duha %v and Midnight %v, want only Duha", tomorrow.Duha, tomorrow.Midnight)
+	}
+}
+
+func TestNearestPrayersDaylightSavingTime(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatalf("Failed to load location: %v", err)
+	}
+	days, err := newTimetableDays(munichTimetable(2023, munich2023))
+	if err != nil {
+		t.Fatalf("newTimetableDays returned error, expected None: %v", err)
+	}
+
+	for _, test := range []struct {
+		description string
+		now         time.Time
+
+		wantPrev     time.Time
+		wantNext     time.Time
+		wantTimeNext time.Duration
+	}{
+		{
+			description: "Spring forward night",
+			now:         time.Date(2023, time.March, 26, 1, 0, 0, 0, berlin),
+			// 2023-03-25 Ishaa 20:12 CET and 2023-03-26 Fajr 05:18 CEST.
+			wantPrev: time.Date(2023, time.March, 25, 20, 12, 0, 0, berlin),
+			wantNext: time.Date(2023, time.March, 26, 5, 18, 0, 0, berlin),
+			// 02:00-03:00 doesn't exist.
+			wantTimeNext: 3*time.Hour + 18*time.Minute,
+		},
+		{
+			description: "Spring forward evening",
+			now:         time.Date(2023, time.March, 25, 22, 0, 0, 0, berlin),
+			wantPrev:    time.Date(2023, time.March, 25, 20, 12, 0, 0, berlin),
+			wantNext:    time.Date(2023, time.March, 26, 5, 18, 0, 0, berlin),
+			// 22:00 CET to 05:18 CEST.
+			wantTimeNext: 6*time.Hour + 18*time.Minute,
+		},
+		{
+			description: "Fall back night",
+			now:         time.Date(2023, time.October, 29, 1, 0, 0, 0, berlin),
+			// 2023-10-28 Ishaa 19:42 CEST and 2023-10-29 Fajr 06:09 CET.
+			wantPrev: time.Date(2023, time.October, 28, 19, 42, 0, 0, berlin),
+			wantNext: time.Date(2023, time.October, 29, 6, 9, 0, 0, berlin),
+			// 02:00-03:00 is repeated.
+			wantTimeNext: 6*time.Hour + 9*time.Minute,
+		},
+	} {
+		t.Run(test.description, func(t *testing.T) {
+			p := &MunichPrayerTimes{TimetablePrayerTimes{days: days}}
+			if err := p.GetTodayPrayerTimes(context.Background(), test.now); err != nil {
+				t.Fatalf("GetTodayPrayerTimes returned error, expected None: %v", err)
+			}
+			prev, next, err := p.GetNearestPrayers(test.now)
+			if err != nil {
+				t.Fatalf("GetNearestPrayers returned error, expected None: %v", err)
+			}
+
+			if !prev.Time.Equal(test.wantPrev) {
+				t.Errorf("Previous prayer mismatch. Got %v, want %v", prev.Time, test.wantPrev)
+			}
+			if !next.Time.Equal(test.wantNext) {
+				t.Errorf("Next prayer mismatch. Got %v, want %v", next.Time, test.wantNext)
+			}
+			if got := next.TimeToPrayer(test.now); got != test.wantTimeNext {
+				t.Errorf("Time to next prayer mismatch. Got %v, want %v", got, test.wantTimeNext)
+			}
+		})
+	}
+}
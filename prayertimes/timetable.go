@@ -0,0 +1,301 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// File backed prayer times. Reads a mosque's published timetable from a CSV or
+// JSON file, e.g. mounted as a docker volume, instead of compiled-in tables.
+//
+// CSV files start with a header row, columns are mapped by their name:
+//
+//	date,fajr,sunrise,dhuhr,asr,maghrib,isha
+//	2024-01-01,06:10,07:59,12:22,14:15,16:35,18:17
+//
+// JSON files contain a list of objects with the same keys:
+//
+//	[{"date": "2024-01-01", "fajr": "06:10", "sunrise": "07:59", ...}]
+
+package prayertimes
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ssafty/adhan-homeassistant-pi/clock"
+)
+
+const (
+	DATE_LAYOUT = "2006-01-02"
+	TIME_LAYOUT = "15:04"
+)
+
+// timetableColumns are the prayer time columns in their daily order.
+var timetableColumns = []string{"fajr", "sunrise", "dhuhr", "asr", "maghrib", "isha"}
+
+// timetableRow is a single day of a timetable.
+type timetableRow struct {
+	// position of the row in its source file for error reporting.
+	file string
+	line int
+
+	date  string
+	times map[string]string
+}
+
+func (r timetableRow) position(column string) string {
+	if column == "" {
+		return fmt.Sprintf("%s:%d", r.file, r.line)
+	}
+	return fmt.Sprintf("%s:%d (%s)", r.file, r.line, column)
+}
+
+// TimetablePrayerTimes extends PrayerTimes to reuse GetNearestPrayers.
+type TimetablePrayerTimes struct {
+	PrayerTimes
+
+	// days maps dates (yyyy-mm-dd) to their timetable row.
+	days map[string]timetableRow
+}
+
+// NewTimetablePrayerTimes reads one or more timetables e.g. a file per year.
+// Dates may only be defined once across all timetables.
+func NewTimetablePrayerTimes(fpaths []string, opts ...PrayerTimesOpt) (*TimetablePrayerTimes, error) {
+	if len(fpaths) == 0 {
+		return nil, errors.New("NewTimetablePrayerTimes's file paths are not specified.")
+	}
+
+	rows := []timetableRow{}
+	for _, fpath := range fpaths {
+		r, err := readTimetable(fpath)
+		if err != nil {
+			return nil, fmt.Errorf("NewTimetablePrayerTimes reading %s failed: %w", fpath, err)
+		}
+		rows = append(rows, r...)
+	}
+	logTimetableIssues(rows)
+
+	days, err := newTimetableDays(rows)
+	if err != nil {
+		return nil, fmt.Errorf("NewTimetablePrayerTimes failed: %w", err)
+	}
+
+	pt := &TimetablePrayerTimes{days: days}
+	for _, opt := range opts {
+		opt(&pt.PrayerTimes)
+	}
+
+	now := clock.OrSystem(pt.clock).Now()
+	if err := pt.GetTodayPrayerTimes(context.Background(), now); err != nil {
+		return nil, fmt.Errorf("Error initializing NewTimetablePrayerTimes for %s: %w", now.Format(DATE_LAYOUT), err)
+	}
+	return pt, nil
+}
+
+// newTimetableDays keys the rows by their full date.
+func newTimetableDays(rows []timetableRow) (map[string]timetableRow, error) {
+	days := map[string]timetableRow{}
+	for _, row := range rows {
+		if _, err := time.Parse(DATE_LAYOUT, row.date); err != nil {
+			return nil, fmt.Errorf("%s: invalid date %q: %w", row.position("date"), row.date, err)
+		}
+		if dup, ok := days[row.date]; ok {
+			return nil, fmt.Errorf("%s: duplicate date %s, first defined at %s", row.position("date"), row.date, dup.position(""))
+		}
+		days[row.date] = row
+	}
+	return days, nil
+}
+
+// GetTodayPrayerTimes reads today's prayer times from the timetable.
+func (p *TimetablePrayerTimes) GetTodayPrayerTimes(ctx context.Context, now time.Time) error {
+	now = p.in(now)
+	if !p.Date.IsZero() && p.Date == GetDate(now) {
+		return nil
+	}
+
+	if err := p.Load(ctx, now, p); err != nil {
+		return err
+	}
+
+	log.Printf("PrayerTimes today: %v", p.PrayerTimes)
+	return nil
+}
+
+// GetPrayerTimes reads the prayer times of the day of a timestamp.
+func (p *TimetablePrayerTimes) GetPrayerTimes(ctx context.Context, day time.Time) (*PrayerTimes, error) {
+	return p.adjust(p.PrayerTimesOn(ctx, day))
+}
+
+// PrayerTimesOn reads the prayer times of a day from the timetable. Days that
+// are not covered fail, unless a fallback is configured.
+func (p *TimetablePrayerTimes) PrayerTimesOn(ctx context.Context, day time.Time) (*PrayerTimes, error) {
+	day = p.in(day)
+	row, ok := p.days[day.Format(DATE_LAYOUT)]
+	if !ok {
+		if p.fallback == nil {
+			return nil, fmt.Errorf("the timetable doesn't cover %s", day.Format(DATE_LAYOUT))
+		}
+		log.Printf("The timetable doesn't cover %s. Using the fallback prayer times.", day.Format(DATE_LAYOUT))
+		return p.fallback.PrayerTimesOn(ctx, day)
+	}
+	return row.PrayerTimesOn(day)
+}
+
+// PrayerTimesOn parses the times of a row on its day.
+func (r timetableRow) PrayerTimesOn(day time.Time) (*PrayerTimes, error) {
+	pts := map[string]time.Time{}
+	for _, column := range timetableColumns {
+		t, ok := r.times[column]
+		if !ok {
+			continue
+		}
+		parsed, err := time.Parse(TIME_LAYOUT, t)
+		if err != nil {
+			return nil, fmt.Errorf("%s: Error parsing prayertime %v: %w", r.position(column), t, err)
+		}
+		pts[column] = WallClock(day, parsed.Hour(), parsed.Minute())
+	}
+
+	pt := &PrayerTimes{
+		Fajr:    &Prayer{Name: "Fajr", Time: pts["fajr"]},
+		Dhuhr:   &Prayer{Name: "Dhuhr", Time: pts["dhuhr"]},
+		Asr:     &Prayer{Name: "Asr", Time: pts["asr"]},
+		Maghrib: &Prayer{Name: "Maghrib", Time: pts["maghrib"]},
+		Ishaa:   &Prayer{Name: "Ishaa", Time: pts["isha"]},
+		Date:    GetDate(day),
+	}
+	// Sunrise is an optional column.
+	if sunrise, ok := pts["sunrise"]; ok {
+		pt.Sunrise = &Prayer{Name: "Sunrise", Time: sunrise}
+	}
+	return pt, nil
+}
+
+// readTimetable reads the rows of a CSV or JSON timetable based on its file
+// extension.
+func readTimetable(fpath string) ([]timetableRow, error) {
+	f, err := os.Open(fpath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	switch ext := strings.ToLower(filepath.Ext(fpath)); ext {
+	case ".csv":
+		return readCSVTimetable(f, fpath)
+	case ".json":
+		return readJSONTimetable(f, fpath)
+	default:
+		return nil, fmt.Errorf("unsupported timetable format %q, expected .csv or .json", ext)
+	}
+}
+
+// readCSVTimetable maps the CSV columns by their header name.
+func readCSVTimetable(r io.Reader, name string) ([]timetableRow, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("%s: error reading the CSV header: %w", name, err)
+	}
+	columns := map[string]int{}
+	for i, h := range header {
+		columns[normalizeColumn(h)] = i
+	}
+	if err := assertColumns(name, func(c string) bool { _, ok := columns[c]; return ok }); err != nil {
+		return nil, err
+	}
+
+	rows := []timetableRow{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: error reading CSV: %w", name, err)
+		}
+
+		line, _ := reader.FieldPos(0)
+		row := timetableRow{file: name, line: line, date: record[columns["date"]], times: map[string]string{}}
+		for _, c := range timetableColumns {
+			if i, ok := columns[c]; ok {
+				row.times[c] = record[i]
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// readJSONTimetable reads a list of objects keyed by the column names. The
+// line of a row is its position in the list.
+func readJSONTimetable(r io.Reader, name string) ([]timetableRow, error) {
+	entries := []map[string]string{}
+	if err := json.NewDecoder(r).Decode(&entries); err != nil {
+		return nil, fmt.Errorf("%s: error decoding JSON: %w", name, err)
+	}
+
+	rows := []timetableRow{}
+	for i, entry := range entries {
+		row := timetableRow{file: name, line: i + 1, times: map[string]string{}}
+		for k, v := range entry {
+			switch c := normalizeColumn(k); c {
+			case "date":
+				row.date = v
+			default:
+				row.times[c] = v
+			}
+		}
+		if err := assertColumns(row.position(""), func(c string) bool {
+			if c == "date" {
+				return row.date != ""
+			}
+			_, ok := row.times[c]
+			return ok
+		}); err != nil {
+			return nil, err
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// assertColumns returns an error if the date or one of the prayer columns
+// is missing. Sunrise is optional.
+func assertColumns(position string, has func(column string) bool) error {
+	for _, c := range append([]string{"date"}, timetableColumns...) {
+		if c != "sunrise" && !has(c) {
+			return fmt.Errorf("%s: missing column %q", position, c)
+		}
+	}
+	return nil
+}
+
+// normalizeColumn maps header names to timetableColumns e.g. " Ishaa" to "isha".
+func normalizeColumn(c string) string {
+	c = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(c, "\ufeff")))
+	if c == "ishaa" {
+		return "isha"
+	}
+	return c
+}
@@ -0,0 +1,425 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prayertimes
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeTimetable writes content to a temporary file with the given name.
+func writeTimetable(t *testing.T, name, content string) string {
+	t.Helper()
+	fpath := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(fpath, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write timetable %v: %v", fpath, err)
+	}
+	return fpath
+}
+
+// adjacentDates returns yesterday's, today's and tomorrow's dates.
+func adjacentDates() []string {
+	now := time.Now()
+	return []string{
+		AdjacentDay(now, -1).Format(DATE_LAYOUT),
+		AdjacentDay(now, 0).Format(DATE_LAYOUT),
+		AdjacentDay(now, 1).Format(DATE_LAYOUT),
+	}
+}
+
+func TestTimetablePrayerTimes(t *testing.T) {
+	dates := adjacentDates()
+
+	for _, test := range []struct {
+		description string
+		name        string
+		content     string
+	}{
+		{
+			description: "CSV",
+			name:        "timetable.csv",
+			content: "date,fajr,sunrise,dhuhr,asr,maghrib,isha\n" +
+				dates[0] + ",05:00,06:29,12:01,15:02,18:03,19:04\n" +
+				dates[1] + ",05:01,06:30,12:02,15:03,18:04,19:05\n" +
+				dates[2] + ",05:02,06:31,12:03,15:04,18:05,19:06\n",
+		},
+		{
+			description: "CSV with reordered columns and extra columns",
+			name:        "timetable.csv",
+			content: "Ishaa, Maghrib, Asr, Dhuhr, Jumuah, Fajr, Date\n" +
+				"19:04, 18:03, 15:02, 12:01, 13:30, 05:00, " + dates[0] + "\n" +
+				"19:05, 18:04, 15:03, 12:02, 13:30, 05:01, " + dates[1] + "\n" +
+				"19:06, 18:05, 15:04, 12:03, 13:30, 05:02, " + dates[2] + "\n",
+		},
+		{
+			description: "JSON",
+			name:        "timetable.json",
+			content: `[
+				{"date": "` + dates[0] + `", "fajr": "05:00", "dhuhr": "12:01", "asr": "15:02", "maghrib": "18:03", "isha": "19:04"},
+				{"date": "` + dates[1] + `", "fajr": "05:01", "sunrise": "06:30", "dhuhr": "12:02",
+				 "asr": "15:03", "maghrib": "18:04", "isha": "19:05"},
+				{"date": "` + dates[2] + `", "fajr": "05:02", "dhuhr": "12:03", "asr": "15:04", "maghrib": "18:05", "isha": "19:06"}]`,
+		},
+	} {
+		t.Run(test.description, func(t *testing.T) {
+			p, err := NewTimetablePrayerTimes([]string{writeTimetable(t, test.name, test.content)})
+			if err != nil {
+				t.Fatalf("NewTimetablePrayerTimes returned error, expected None: %v", err)
+			}
+
+			for _, c := range []struct {
+				got  *Prayer
+				want string
+			}{
+				{p.Fajr, "05:01"},
+				{p.Dhuhr, "12:02"},
+				{p.Asr, "15:03"},
+				{p.Maghrib, "18:04"},
+				{p.Ishaa, "19:05"},
+			} {
+				if got := c.got.Time.Format(TIME_LAYOUT); got != c.want || !isSameDay(time.Now(), c.got.Time) {
+					t.Errorf("%v time mismatch. Got %v, want %v today", c.got.Name, c.got.Time, c.want)
+				}
+			}
+		})
+	}
+}
+
+func TestInvalidTimetablePrayerTimes(t *testing.T) {
+	dates := adjacentDates()
+	today := dates[1]
+
+	for _, test := range []struct {
+		description string
+		name        string
+		content     string
+	}{
+		{
+			description: "Unsupported format",
+			name:        "timetable.txt",
+			content:     "",
+		},
+		{
+			description: "Missing column",
+			name:        "timetable.csv",
+			content: "date,fajr,dhuhr,asr,maghrib\n" +
+				today + ",05:01,12:02,15:03,18:04\n",
+		},
+		{
+			description: "Today is not covered",
+			name:        "timetable.csv",
+			content: "date,fajr,dhuhr,asr,maghrib,isha\n" +
+				"2000-01-01,05:01,12:02,15:03,18:04,19:05\n",
+		},
+		{
+			description: "Invalid date",
+			name:        "timetable.csv",
+			content: "date,fajr,dhuhr,asr,maghrib,isha\n" +
+				"01.01.2000,05:01,12:02,15:03,18:04,19:05\n",
+		},
+		{
+			description: "Duplicate date",
+			name:        "timetable.csv",
+			content: "date,fajr,dhuhr,asr,maghrib,isha\n" +
+				today + ",05:01,12:02,15:03,18:04,19:05\n" +
+				today + ",05:01,12:02,15:03,18:04,19:05\n",
+		},
+		{
+			description: "Invalid time",
+			name:        "timetable.json",
+			content:     `[{"date": "` + today + `", "fajr": "5 am", "dhuhr": "12:02", "asr": "15:03", "maghrib": "18:04", "isha": "19:05"}]`,
+		},
+		{
+			description: "JSON entry with missing column",
+			name:        "timetable.json",
+			content:     `[{"date": "` + today + `", "dhuhr": "12:02", "asr": "15:03", "maghrib": "18:04", "isha": "19:05"}]`,
+		},
+	} {
+		t.Run(test.description, func(t *testing.T) {
+			if _, err := NewTimetablePrayerTimes([]string{writeTimetable(t, test.name, test.content)}); err == nil {
+				t.Errorf("NewTimetablePrayerTimes should raise an error. Got none.")
+			}
+		})
+	}
+}
+
+func TestMultiYearTimetablePrayerTimes(t *testing.T) {
+	header := "date,fajr,dhuhr,asr,maghrib,isha\n"
+	today := ""
+	for _, date := range adjacentDates() {
+		today += date + ",05:01,12:02,15:03,18:04,19:05\n"
+	}
+	p, err := NewTimetablePrayerTimes([]string{
+		writeTimetable(t, "2024.csv", header+"2024-12-30,06:10,12:21,14:14,16:33,18:15\n2024-12-31,06:10,12:21,14:14,16:34,18:16\n"),
+		writeTimetable(t, "2025.csv", header+"2025-01-01,06:11,12:22,14:15,16:35,18:17\n2025-01-02,06:11,12:22,14:16,16:36,18:18\n"),
+		writeTimetable(t, "today.csv", header+today),
+	})
+	if err != nil {
+		t.Fatalf("NewTimetablePrayerTimes returned error, expected None: %v", err)
+	}
+
+	for _, test := range []struct {
+		day      time.Time
+		wantFajr string
+		wantErr  bool
+	}{
+		{day: time.Date(2024, time.December, 31, 10, 0, 0, 0, time.UTC), wantFajr: "06:10"},
+		{day: time.Date(2025, time.January, 1, 10, 0, 0, 0, time.UTC), wantFajr: "06:11"},
+		// Same day and month of a year that is not covered.
+		{day: time.Date(2023, time.December, 31, 10, 0, 0, 0, time.UTC), wantErr: true},
+		// The previous day is not covered.
+		{day: time.Date(2024, time.December, 30, 10, 0, 0, 0, time.UTC), wantFajr: "06:10"},
+	} {
+		t.Run(test.day.Format(DATE_LAYOUT), func(t *testing.T) {
+			err := p.GetTodayPrayerTimes(context.Background(), test.day)
+			if gotErr := err != nil; gotErr != test.wantErr {
+				t.Fatalf("GetTodayPrayerTimes error mismatch. Got %v, want error: %v", err, test.wantErr)
+			}
+			if test.wantErr {
+				return
+			}
+			if got := p.Fajr.Time.Format(TIME_LAYOUT); got != test.wantFajr || !isSameDay(test.day, p.Fajr.Time) {
+				t.Errorf("Fajr mismatch. Got %v, want %v on %v", p.Fajr.Time, test.wantFajr, test.day)
+			}
+		})
+	}
+}
+
+func TestTimetableFirstAndLastDays(t *testing.T) {
+	rows, err := readCSVTimetable(strings.NewReader(`date,fajr,dhuhr,asr,maghrib,isha
+2024-03-01,05:01,12:02,15:03,18:04,19:05
+2024-03-02,05:00,12:02,15:04,18:05,19:06
+`), "timetable.csv")
+	if err != nil {
+		t.Fatalf("readCSVTimetable returned error, expected None: %v", err)
+	}
+	days, err := newTimetableDays(rows)
+	if err != nil {
+		t.Fatalf("newTimetableDays returned error, expected None: %v", err)
+	}
+	p := &TimetablePrayerTimes{days: days}
+	Timezone(time.UTC)(&p.PrayerTimes)
+
+	for _, test := range []struct {
+		description string
+		now         time.Time
+
+		wantPrev string
+		wantNext string
+		// wantErr is part of the error, including the missing day's cause.
+		wantErr string
+	}{
+		{
+			description: "First day between prayers",
+			now:         time.Date(2024, time.March, 1, 10, 0, 0, 0, time.UTC),
+			wantPrev:    "Fajr",
+			wantNext:    "Dhuhr",
+		},
+		{
+			description: "First day after Ishaa",
+			now:         time.Date(2024, time.March, 1, 21, 0, 0, 0, time.UTC),
+			wantPrev:    "Ishaa",
+			wantNext:    "Fajr",
+		},
+		{
+			description: "First day before Fajr needs the previous day",
+			now:         time.Date(2024, time.March, 1, 4, 0, 0, 0, time.UTC),
+			wantErr:     "Yesterday is not covered: the timetable doesn't cover 2024-02-29",
+		},
+		{
+			description: "Last day before Fajr",
+			now:         time.Date(2024, time.March, 2, 4, 0, 0, 0, time.UTC),
+			wantPrev:    "Ishaa",
+			wantNext:    "Fajr",
+		},
+		{
+			description: "Last day between prayers",
+			now:         time.Date(2024, time.March, 2, 16, 0, 0, 0, time.UTC),
+			wantPrev:    "Asr",
+			wantNext:    "Maghrib",
+		},
+		{
+			description: "Last day after Ishaa needs the next day",
+			now:         time.Date(2024, time.March, 2, 21, 0, 0, 0, time.UTC),
+			wantErr:     "Tomorrow is not covered: the timetable doesn't cover 2024-03-03",
+		},
+	} {
+		t.Run(test.description, func(t *testing.T) {
+			if err := p.GetTodayPrayerTimes(context.Background(), test.now); err != nil {
+				t.Fatalf("GetTodayPrayerTimes returned error, expected None: %v", err)
+			}
+			if got, want := len(p.GetDays()), 2; got != want {
+				t.Errorf("Loaded days mismatch. Got %v, want %v", got, want)
+			}
+
+			prev, next, err := p.GetNearestPrayers(test.now)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Errorf("GetNearestPrayers error mismatch. Got %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("GetNearestPrayers returned error, expected None: %v", err)
+			}
+			if prev.Name != test.wantPrev || next.Name != test.wantNext {
+				t.Errorf("Nearest prayers mismatch. Got %v and %v, want %v and %v", prev.Name, next.Name, test.wantPrev, test.wantNext)
+			}
+		})
+	}
+}
+
+func TestTimetablePrayerTimesDaylightSavingTime(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatalf("Failed to load location: %v", err)
+	}
+
+	// Fajr at 02:30 doesn't exist on 2023-03-26 and is repeated on 2023-10-29.
+	rows, err := readCSVTimetable(strings.NewReader(`date,fajr,dhuhr,asr,maghrib,isha
+2023-03-25,04:21,12:25,15:48,18:38,20:12
+2023-03-26,02:30,13:25,16:48,19:39,21:18
+2023-03-27,05:16,13:24,16:49,19:41,21:20
+2023-10-28,06:07,13:03,15:37,18:08,19:42
+2023-10-29,02:30,12:02,14:36,17:06,18:40
+2023-10-30,05:10,12:02,14:34,17:05,18:39
+`), "dst.csv")
+	if err != nil {
+		t.Fatalf("readCSVTimetable returned error, expected None: %v", err)
+	}
+	days, err := newTimetableDays(rows)
+	if err != nil {
+		t.Fatalf("newTimetableDays returned error, expected None: %v", err)
+	}
+
+	for _, test := range []struct {
+		description string
+		// now is in UTC to test the conversion to the timezone.
+		now time.Time
+
+		wantDate     time.Time
+		wantFajr     time.Time
+		wantTimeNext time.Duration
+	}{
+		{
+			description: "Nonexistent hour",
+			// 01:30 CET.
+			now:      time.Date(2023, time.March, 26, 0, 30, 0, 0, time.UTC),
+			wantDate: time.Date(2023, time.March, 26, 0, 0, 0, 0, berlin),
+			// Clocks jump from 02:00 CET to 03:00 CEST.
+			wantFajr:     time.Date(2023, time.March, 26, 1, 0, 0, 0, time.UTC),
+			wantTimeNext: 30 * time.Minute,
+		},
+		{
+			description: "Repeated hour",
+			// 01:30 CEST on the next day in Berlin.
+			now:      time.Date(2023, time.October, 28, 23, 30, 0, 0, time.UTC),
+			wantDate: time.Date(2023, time.October, 29, 0, 0, 0, 0, berlin),
+			// The first 02:30 is CEST.
+			wantFajr:     time.Date(2023, time.October, 29, 0, 30, 0, 0, time.UTC),
+			wantTimeNext: time.Hour,
+		},
+	} {
+		t.Run(test.description, func(t *testing.T) {
+			p := &TimetablePrayerTimes{days: days}
+			Timezone(berlin)(&p.PrayerTimes)
+
+			if err := p.GetTodayPrayerTimes(context.Background(), test.now); err != nil {
+				t.Fatalf("GetTodayPrayerTimes returned error, expected None: %v", err)
+			}
+			if !p.Date.Equal(test.wantDate) {
+				t.Errorf("Prayer times date mismatch. Got %v, want %v", p.Date, test.wantDate)
+			}
+			if !p.Fajr.Time.Equal(test.wantFajr) || p.Fajr.Time.Location() != berlin {
+				t.Errorf("Fajr mismatch. Got %v, want %v in %v", p.Fajr.Time, test.wantFajr, berlin)
+			}
+
+			_, next, err := p.GetNearestPrayers(test.now)
+			if err != nil {
+				t.Fatalf("GetNearestPrayers returned error, expected None: %v", err)
+			}
+			if next != p.Fajr {
+				t.Errorf("Next prayer mismatch. Got %v at %v, want Fajr", next.Name, next.Time)
+			}
+			if got := next.TimeToPrayer(test.now); got != test.wantTimeNext {
+				t.Errorf("Time to next prayer mismatch. Got %v, want %v", got, test.wantTimeNext)
+			}
+		})
+	}
+}
+
+func TestMunichPrayerTimesYears(t *testing.T) {
+	days, err := newTimetableDays(munichTimetable(2023, munich2023))
+	if err != nil {
+		t.Fatalf("newTimetableDays returned error, expected None: %v", err)
+	}
+	if got, want := len(days), 365; got != want {
+		t.Errorf("Munich timetable days mismatch. Got %v, want %v", got, want)
+	}
+
+	for _, test := range []struct {
+		description string
+		day         time.Time
+		fallback    bool
+		wantErr     bool
+	}{
+		{
+			description: "Covered date",
+			day:         time.Date(2023, time.February, 28, 10, 0, 0, 0, time.UTC),
+		},
+		{
+			description: "First day",
+			day:         time.Date(2023, time.January, 1, 10, 0, 0, 0, time.UTC),
+		},
+		{
+			description: "Last day",
+			day:         time.Date(2023, time.December, 31, 10, 0, 0, 0, time.UTC),
+		},
+		{
+			description: "Following year is not covered",
+			day:         time.Date(2024, time.January, 1, 10, 0, 0, 0, time.UTC),
+			wantErr:     true,
+		},
+		{
+			description: "Leap day is not covered",
+			day:         time.Date(2024, time.February, 29, 10, 0, 0, 0, time.UTC),
+			wantErr:     true,
+		},
+		{
+			description: "Leap day falls back to the calculator",
+			day:         time.Date(2024, time.February, 29, 10, 0, 0, 0, time.UTC),
+			fallback:    true,
+		},
+	} {
+		t.Run(test.description, func(t *testing.T) {
+			p := &MunichPrayerTimes{TimetablePrayerTimes{days: days}}
+			if test.fallback {
+				Fallback(&CalculatedPrayerTimes{params: munichParams})(&p.PrayerTimes)
+			}
+
+			err := p.GetTodayPrayerTimes(context.Background(), test.day)
+			if gotErr := err != nil; gotErr != test.wantErr {
+				t.Fatalf("GetTodayPrayerTimes error mismatch. Got %v, want error: %v", err, test.wantErr)
+			}
+			if !test.wantErr && !isSameDay(test.day, p.Fajr.Time, p.Dhuhr.Time, p.Asr.Time, p.Maghrib.Time, p.Ishaa.Time) {
+				t.Errorf("Prayer times should be on %v. Got %v", test.day, p.PrayerTimes)
+			}
+		})
+	}
+}
@@ -0,0 +1,164 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Timetable linter. Finds entries that parse fine but are likely wrong e.g.
+// "0:52" instead of "05:52", prayers out of order or implausible jumps between
+// consecutive days.
+
+package prayertimes
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// MAX_DAILY_CHANGE is the largest plausible change of a prayer time between
+// consecutive days, apart from daylight saving time changes.
+const MAX_DAILY_CHANGE = 8 * time.Minute
+
+// TimeFormat matches the hh:mm times of timetables and flags e.g. "05:52".
+var TimeFormat = regexp.MustCompile(`^([01][0-9]|2[0-3]):[0-5][0-9]$`)
+
+// logTimetableIssues validates the timetable rows on startup and logs the issues
+// as warnings.
+func logTimetableIssues(rows []timetableRow) {
+	for _, issue := range validateTimetable(rows) {
+		log.Printf("Warning: timetable issue: %v", issue)
+	}
+}
+
+// validateTimetable returns all issues found in the timetable rows. Each issue
+// starts with the row and column position.
+func validateTimetable(rows []timetableRow) []error {
+	issues := []error{}
+
+	// minutes since midnight per row and column.
+	parsed := make([]map[string]time.Duration, len(rows))
+	dates := make([]time.Time, len(rows))
+
+	for i, row := range rows {
+		d, err := time.Parse(DATE_LAYOUT, row.date)
+		if err != nil {
+			issues = append(issues, fmt.Errorf("%s: invalid date %q, expected yyyy-mm-dd", row.position("date"), row.date))
+		}
+		dates[i] = d
+
+		parsed[i] = map[string]time.Duration{}
+		var prev string
+		for _, c := range timetableColumns {
+			t, ok := row.times[c]
+			if !ok {
+				continue
+			}
+			if !TimeFormat.MatchString(t) {
+				issues = append(issues, fmt.Errorf("%s: malformed time %q, expected hh:mm", row.position(c), t))
+				continue
+			}
+			clock, _ := time.Parse(TIME_LAYOUT, t)
+			parsed[i][c] = time.Duration(clock.Hour())*time.Hour + time.Duration(clock.Minute())*time.Minute
+
+			if prev != "" && parsed[i][c] <= parsed[i][prev] {
+				issues = append(issues, fmt.Errorf("%s: %s %s is not after %s %s", row.position(c), c, t, prev, row.times[prev]))
+			}
+			prev = c
+		}
+	}
+
+	// Compare consecutive days in date order.
+	order := make([]int, len(rows))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return dates[order[a]].Before(dates[order[b]]) })
+
+	for k := 1; k < len(order); k++ {
+		prev, cur := order[k-1], order[k]
+		if dates[prev].IsZero() || !dates[prev].AddDate(0, 0, 1).Equal(dates[cur]) {
+			continue
+		}
+
+		changes := map[string]time.Duration{}
+		for _, c := range timetableColumns {
+			a, okA := parsed[prev][c]
+			b, okB := parsed[cur][c]
+			if okA && okB {
+				changes[c] = b - a
+			}
+		}
+		if isDaylightSavingChange(changes) {
+			continue
+		}
+
+		for _, c := range timetableColumns {
+			change, ok := changes[c]
+			if ok && (change > MAX_DAILY_CHANGE || change < -MAX_DAILY_CHANGE) {
+				issues = append(issues, fmt.Errorf("%s: %s changes by %v from %s at %s", rows[cur].position(c), c, change, rows[prev].times[c], rows[prev].position("")))
+			}
+		}
+	}
+	return issues
+}
+
+// isDaylightSavingChange returns True if all prayers moved by about an hour in
+// the same direction.
+func isDaylightSavingChange(changes map[string]time.Duration) bool {
+	if len(changes) == 0 {
+		return false
+	}
+	for _, shift := range []time.Duration{time.Hour, -time.Hour} {
+		all := true
+		for _, change := range changes {
+			if d := change - shift; d > MAX_DAILY_CHANGE || d < -MAX_DAILY_CHANGE {
+				all = false
+				break
+			}
+		}
+		if all {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateTimetables lints the timetable files, defaulting to the static
+// Munich timetable, and logs all issues.
+func ValidateTimetables(fpaths []string) error {
+	rows := []timetableRow{}
+	if len(fpaths) == 0 {
+		rows = munichTimetable(2023, munich2023)
+	}
+	for _, fpath := range fpaths {
+		r, err := readTimetable(fpath)
+		if err != nil {
+			return fmt.Errorf("error reading %s: %w", fpath, err)
+		}
+		rows = append(rows, r...)
+	}
+
+	issues := validateTimetable(rows)
+	if _, err := newTimetableDays(rows); err != nil {
+		issues = append(issues, err)
+	}
+	for _, issue := range issues {
+		log.Println(issue)
+	}
+	if len(issues) > 0 {
+		return fmt.Errorf("found %d issues in %d timetable rows", len(issues), len(rows))
+	}
+	log.Printf("No issues found in %d timetable rows.", len(rows))
+	return nil
+}
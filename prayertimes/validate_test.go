@@ -0,0 +1,116 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prayertimes
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestValidateTimetable(t *testing.T) {
+	row := func(line int, date string, times ...string) timetableRow {
+		r := timetableRow{file: "t.csv", line: line, date: date, times: map[string]string{}}
+		for i, c := range timetableColumns {
+			r.times[c] = times[i]
+		}
+		return r
+	}
+
+	for _, test := range []struct {
+		description string
+		rows        []timetableRow
+
+		wantIssues []string
+	}{
+		{
+			description: "Valid timetable",
+			rows: []timetableRow{
+				row(2, "2023-01-01", "06:10", "07:59", "12:22", "14:15", "16:35", "18:17"),
+				row(3, "2023-01-02", "06:11", "07:59", "12:23", "14:15", "16:36", "18:18"),
+			},
+		},
+		{
+			description: "Malformed times",
+			rows: []timetableRow{
+				row(2, "2023-12-03", "0:52", "07:40", "12:08", "14:05", "16:27", "18:08"),
+				row(3, "2023-08-21", "04:06", "06:18", "13:16", "17:06", "20:2", "21:57"),
+			},
+			wantIssues: []string{
+				`t.csv:2 (fajr): malformed time "0:52", expected hh:mm`,
+				`t.csv:3 (maghrib): malformed time "20:2", expected hh:mm`,
+			},
+		},
+		{
+			description: "Invalid date",
+			rows: []timetableRow{
+				row(2, "03.12.2023", "05:52", "07:40", "12:08", "14:05", "16:27", "18:08"),
+			},
+			wantIssues: []string{
+				`t.csv:2 (date): invalid date "03.12.2023", expected yyyy-mm-dd`,
+			},
+		},
+		{
+			description: "Prayers out of order",
+			rows: []timetableRow{
+				row(2, "2023-01-01", "06:10", "07:59", "12:22", "12:15", "16:35", "16:35"),
+			},
+			wantIssues: []string{
+				"t.csv:2 (asr): asr 12:15 is not after dhuhr 12:22",
+				"t.csv:2 (isha): isha 16:35 is not after maghrib 16:35",
+			},
+		},
+		{
+			description: "Implausible jump between unordered rows",
+			rows: []timetableRow{
+				row(2, "2023-06-13", "03:37", "05:11", "13:17", "17:28", "21:13", "22:48"),
+				row(3, "2023-06-11", "03:37", "05:11", "13:17", "17:28", "21:12", "22:48"),
+				row(4, "2023-06-12", "03:47", "05:11", "13:17", "17:28", "21:12", "22:48"),
+			},
+			wantIssues: []string{
+				"t.csv:4 (fajr): fajr changes by 10m0s from 03:37 at t.csv:3",
+				"t.csv:2 (fajr): fajr changes by -10m0s from 03:47 at t.csv:4",
+			},
+		},
+		{
+			description: "Daylight saving time change",
+			rows: []timetableRow{
+				row(2, "2023-03-25", "04:23", "06:04", "12:25", "15:47", "18:36", "20:11"),
+				row(3, "2023-03-26", "05:18", "07:00", "13:25", "16:48", "19:39", "21:18"),
+			},
+		},
+		{
+			description: "Gaps between days are not compared",
+			rows: []timetableRow{
+				row(2, "2023-01-01", "06:10", "07:59", "12:22", "14:15", "16:35", "18:17"),
+				row(3, "2023-03-01", "05:12", "06:51", "12:31", "15:25", "18:02", "19:34"),
+			},
+		},
+	} {
+		t.Run(test.description, func(t *testing.T) {
+			got := []string{}
+			for _, issue := range validateTimetable(test.rows) {
+				got = append(got, issue.Error())
+			}
+			if test.wantIssues == nil {
+				test.wantIssues = []string{}
+			}
+			if !cmp.Equal(got, test.wantIssues) {
+				t.Errorf("validateTimetable issues mismatch. Got:\n%v\nwant:\n%v", strings.Join(got, "\n"), strings.Join(test.wantIssues, "\n"))
+			}
+		})
+	}
+}